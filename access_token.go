@@ -1,19 +1,215 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 	
+	"github.com/google/uuid"
 	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
 )
 
+// denylistStore adapts the generated queries to auth.DenylistStore
+type denylistStore struct {
+	db	*database.Queries
+}
+
+func (s denylistStore) DenyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return s.db.DenyToken(ctx, database.DenyTokenParams{
+		Jti:		jti,
+		ExpiresAt:	expiresAt,
+	})
+}
+
+func (s denylistStore) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	return s.db.IsTokenDenied(ctx, jti)
+}
+
+func (s denylistStore) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	return s.db.DeleteExpiredDeniedTokens(ctx)
+}
+
+// runDenylistCleanup prunes expired revocations every interval until ctx is
+// cancelled
+func (cfg *apiConfig) runDenylistCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pruneCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		_, err := cfg.denylist.Prune(pruneCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Error pruning token denylist", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// validateAccessToken checks the JWT signature and expiry, then rejects
+// tokens whose jti has been revoked.
+func (cfg *apiConfig) validateAccessToken(ctx context.Context, tokenString string) (*auth.Claims, error) {
+	claims, err := auth.ParseJWT(tokenString, cfg.jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	denied, err := cfg.denylist.IsDenied(ctx, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if denied {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+	return claims, nil
+}
+
+// caller describes who an authenticated request is acting for
+type caller struct {
+	userID		uuid.UUID
+	// Empty for first-party tokens
+	clientID	string
+	// Set when authenticated with a personal API token
+	apiTokenID	uuid.UUID
+	scope		string
+	// Nil for personal API tokens
+	claims		*auth.Claims
+}
+
+// firstParty reports whether the caller holds a token from logging in
+// directly, which carries every scope
+func (c caller) firstParty() bool {
+	return c.clientID == "" && c.apiTokenID == uuid.Nil
+}
+
+// authenticate identifies the user behind the request and checks their
+// credentials grant scope, responding with an error if not.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request, scope string) (caller, bool) {
+	token, _, err := requestToken(r, accessTokenCookie)
+	if errors.Is(err, auth.ErrCSRFTokenMismatch) {
+		respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token", err)
+		return caller{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find token", err)
+		return caller{}, false
+	}
+
+	var c caller
+	if strings.HasPrefix(token, apiTokenPrefix) {
+		c, err = cfg.validateAPIToken(r.Context(), token)
+	} else {
+		c, err = cfg.accessTokenCaller(r.Context(), token)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+		return caller{}, false
+	}
+
+	if !scopeAllows(c.firstParty(), c.scope, scope) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Token doesn't grant the %s scope", scope), nil)
+		return caller{}, false
+	}
+	setRequestUser(r.Context(), c.userID)
+	return c, true
+}
+
+func (cfg *apiConfig) accessTokenCaller(ctx context.Context, token string) (caller, error) {
+	claims, err := cfg.validateAccessToken(ctx, token)
+	if err != nil {
+		return caller{}, err
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return caller{}, err
+	}
+
+	return caller{
+		userID:		userID,
+		clientID:	claims.ClientID,
+		scope:		claims.Scope,
+		claims:		claims,
+	}, nil
+}
+
+// makeSessionAccessToken issues an access token for the session identified by
+// refreshToken and records its jti on the session, so revoking the session
+// also revokes the access token. Sessions belonging to an OAuth client get a
+// token limited to the scope the user granted.
+func (cfg *apiConfig) makeSessionAccessToken(ctx context.Context, userID uuid.UUID, refreshToken, clientID, scope string, expiresIn time.Duration) (string, error) {
+	accessToken, err := auth.MakeScopedJWT(userID, cfg.jwtSecret, clientID, scope, expiresIn)
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := auth.ParseJWT(accessToken, cfg.jwtSecret)
+	if err != nil {
+		return "", err
+	}
+
+	err = cfg.db.UpdateRefreshTokenAccessToken(ctx, database.UpdateRefreshTokenAccessTokenParams{
+		Token:			refreshToken,
+		AccessTokenJti:		sql.NullString{String: claims.ID, Valid: true},
+		AccessTokenExpiresAt:	sql.NullTime{Time: claims.ExpiresAt.Time, Valid: true},
+	})
+	if err != nil {
+		return "", err
+	}
+	return accessToken, nil
+}
+
+// denySessionAccessToken revokes the access token last issued for a session, if any
+func (cfg *apiConfig) denySessionAccessToken(ctx context.Context, jti sql.NullString, expiresAt sql.NullTime) error {
+	if !jti.Valid || !expiresAt.Valid {
+		return nil
+	}
+	return cfg.denylist.Deny(ctx, jti.String, expiresAt.Time)
+}
+
+// revokeAllSessions revokes every refresh token belonging to the user along
+// with the access tokens issued from them, and their personal API tokens,
+// which would otherwise outlive the password they were created under.
+func (cfg *apiConfig) revokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	err := cfg.db.RevokeUserAPITokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := cfg.db.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		err = cfg.denySessionAccessToken(ctx, session.AccessTokenJti, session.AccessTokenExpiresAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 
 func (cfg *apiConfig) handlerRefreshAccessToken(w http.ResponseWriter, r *http.Request) {
 	type AccessToken struct {
 		TokenString	string `json:"token"`
 	}
 
-	tokenString, err := auth.GetBearerToken(r.Header)
+	tokenString, fromCookie, err := requestToken(r, refreshTokenCookie)
+	if errors.Is(err, auth.ErrCSRFTokenMismatch) {
+		respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token", err)
+		return
+	}
 	if err != nil { 
 		respondWithError(w, http.StatusInternalServerError, "Couldn't find token", err)
 		return
@@ -31,29 +227,69 @@ func (cfg *apiConfig) handlerRefreshAccessToken(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	// OAuth clients refresh through the token endpoint so their scope is kept
+	if token.ClientID.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token belongs to an OAuth client", nil)
+		return
+	}
+
+	// The new JWT replaces the one previously issued for this session
+	err = cfg.denySessionAccessToken(r.Context(), token.AccessTokenJti, token.AccessTokenExpiresAt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke previous access token", err)
+		return
+	}
+
 	// create a new JWT
-	jwt, err := auth.MakeJWT(token.UserID, cfg.jwtSecret, time.Hour)
+	jwt, err := cfg.makeSessionAccessToken(r.Context(), token.UserID, token.Token, "", "", time.Hour)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token", err)
 		return
 	}
 
+	// Browser sessions get the new token as a cookie, out of reach of scripts
+	if fromCookie {
+		setAccessTokenCookie(w, jwt, time.Hour)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, AccessToken{
 		TokenString:	jwt,
 	})
 }
 
 func (cfg *apiConfig) handlerRevokeAccessToken(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header) 
+	token, fromCookie, err := requestToken(r, refreshTokenCookie)
+	if errors.Is(err, auth.ErrCSRFTokenMismatch) {
+		respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token", err)
+		return
+	}
 	if err != nil { 
 		respondWithError(w, http.StatusInternalServerError, "Couldn't find token", err)
 		return
 	}
 
+	session, err := cfg.db.GetUserFromRefreshToken(r.Context(), token)
+	if err != nil { 
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user refresh token", err)
+		return
+	}
+
 	err = cfg.db.UpdateTokenRevokedAt(r.Context(), token)
 	if err != nil { 
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
 	}
+
+	err = cfg.denySessionAccessToken(r.Context(), session.AccessTokenJti, session.AccessTokenExpiresAt)
+	if err != nil { 
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access token", err)
+		return
+	}
+
+	if fromCookie {
+		clearSessionCookies(w)
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/pjjimiso/chirpy/internal/auth"
+)
+
+// requireAdmin checks for the ADMIN_API_KEY in an "Authorization: ApiKey"
+// header. Admin endpoints stay closed until a key is configured.
+func (cfg *apiConfig) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if cfg.adminApiKey == "" {
+		respondWithError(w, http.StatusForbidden, "Admin API isn't enabled", nil)
+		return false
+	}
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil || subtle.ConstantTimeCompare([]byte(key), []byte(cfg.adminApiKey)) != 1 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid admin API key", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/mailer"
+)
+
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password	string	`json:"password"`
+		// From signing in again with an identity provider, instead of the
+		// password. Browsers get it as a cookie.
+		ReauthToken	string	`json:"reauth_token"`
+		// Keep chirps up without an author instead of deleting them
+		KeepChirps	bool	`json:"keep_chirps"`
+	}
+	type response struct {
+		DeleteAfter	time.Time	`json:"delete_after"`
+		KeepChirps	bool		`json:"keep_chirps"`
+	}
+
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), caller.userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+
+	// A stolen session alone isn't enough to delete the account. It takes
+	// the password, or for accounts created through an identity provider
+	// (which only have a random one) a fresh sign-in with the provider.
+	reauthToken := params.ReauthToken
+	if cookie, err := r.Cookie(reauthCookie); reauthToken == "" && err == nil {
+		reauthToken = cookie.Value
+	}
+	if reauthToken != "" {
+		if !cfg.validReauthToken(reauthToken, user.ID) {
+			respondWithError(w, http.StatusUnauthorized, "Sign in with your identity provider again", nil)
+			return
+		}
+	} else {
+		// Kept apart from the login counters, so a mistyped password here
+		// can't lock the account out of logging in
+		accountKey := "delete:" + accountThrottleKey(user.Email)
+		if !cfg.loginAllowed(w, r, accountKey) {
+			return
+		}
+		_, err = cfg.checkCredentials(r, accountKey, user.Email, params.Password)
+		if errors.Is(err, errInvalidCredentials) {
+			respondWithError(w, http.StatusUnauthorized, "Incorrect password", nil)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check password", err)
+			return
+		}
+	}
+
+	deleteAfter := time.Now().Add(cfg.deletionGracePeriod)
+	err = cfg.db.ScheduleUserDeletion(r.Context(), database.ScheduleUserDeletionParams{
+		ID:		user.ID,
+		DeleteAfter:	sql.NullTime{Time: deleteAfter, Valid: true},
+		KeepChirps:	params.KeepChirps,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule deletion", err)
+		return
+	}
+
+	// Signing out everywhere means carrying on requires a login, which is
+	// what cancels the deletion
+	err = cfg.revokeAllSessions(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	if caller.claims != nil {
+		err = cfg.denylist.Deny(r.Context(), caller.claims.ID, caller.claims.ExpiresAt.Time)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access token", err)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:		reauthCookie,
+		Value:		"",
+		Path:		"/",
+		MaxAge:		-1,
+		HttpOnly:	true,
+		Secure:		true,
+		SameSite:	http.SameSiteStrictMode,
+	})
+	cfg.goBackground(func() { cfg.sendDeletionScheduledEmail(user.Email, deleteAfter) })
+
+	respondWithJSON(w, http.StatusAccepted, response{
+		DeleteAfter:	deleteAfter,
+		KeepChirps:	params.KeepChirps,
+	})
+}
+
+func (cfg *apiConfig) sendDeletionScheduledEmail(email string, deleteAfter time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+	defer cancel()
+
+	err := cfg.mailer.Send(ctx, mailer.Message{
+		To:		email,
+		Subject:	"Your Chirpy account will be deleted",
+		Body:		fmt.Sprintf("Your Chirpy account is scheduled for deletion on %s.\n\nIf you change your mind, just log in before then and the deletion will be cancelled.", deleteAfter.UTC().Format("January 2, 2006 at 15:04 UTC")),
+	})
+	if err != nil {
+		slog.Error("Error sending account deletion email", "error", err)
+	}
+}
+
+// cancelPendingDeletion is called on every login, since logging in during
+// the grace period is how a user changes their mind
+func (cfg *apiConfig) cancelPendingDeletion(ctx context.Context, userID uuid.UUID) error {
+	_, err := cfg.db.CancelUserDeletion(ctx, userID)
+	return err
+}
+
+// runAccountDeletion purges accounts whose grace period has ended, checking
+// every interval until ctx is cancelled
+func (cfg *apiConfig) runAccountDeletion(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cfg.deleteDueAccounts(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deleteDueAccounts stops between accounts once ctx is cancelled, but an
+// account already being deleted is finished
+func (cfg *apiConfig) deleteDueAccounts(ctx context.Context) {
+	opCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	users, err := cfg.db.GetUsersDueForDeletion(opCtx)
+	if err != nil {
+		slog.Error("Error listing accounts due for deletion", "error", err)
+		return
+	}
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return
+		}
+		err := cfg.deleteAccount(opCtx, user.ID, user.KeepChirps)
+		if err != nil {
+			slog.Error("Error deleting account", "user_id", user.ID, "error", err)
+		}
+	}
+}
+
+// deleteAccount removes a user and, through ON DELETE CASCADE, everything
+// they own. Kept chirps are detached first, in the same transaction so a
+// login cancelling the deletion can't leave them orphaned.
+func (cfg *apiConfig) deleteAccount(ctx context.Context, userID uuid.UUID, keepChirps bool) error {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if keepChirps {
+		err = qtx.AnonymizeUserChirps(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only deletes if the deletion is still due, in case it was cancelled
+	// since the account was listed
+	deleted, err := qtx.DeleteUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return nil
+	}
+	return tx.Commit()
+}
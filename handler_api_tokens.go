@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+)
+
+// apiTokenPrefix marks personal API tokens so they can be told apart from
+// JWTs without a database lookup, and found by secret scanners
+const apiTokenPrefix = "chirpy_pat_"
+
+type APIToken struct {
+	ID		uuid.UUID	`json:"id"`
+	Name		string		`json:"name"`
+	Scope		string		`json:"scope"`
+	Token		string		`json:"token,omitempty"`
+	CreatedAt	time.Time	`json:"created_at"`
+	ExpiresAt	*time.Time	`json:"expires_at"`
+	LastUsedAt	*time.Time	`json:"last_used_at"`
+}
+
+func apiTokenFromDB(token database.ApiToken) APIToken {
+	t := APIToken{
+		ID:		token.ID,
+		Name:		token.Name,
+		Scope:		token.Scope,
+		CreatedAt:	token.CreatedAt,
+	}
+	if token.ExpiresAt.Valid {
+		t.ExpiresAt = &token.ExpiresAt.Time
+	}
+	if token.LastUsedAt.Valid {
+		t.LastUsedAt = &token.LastUsedAt.Time
+	}
+	return t
+}
+
+// validateAPIToken looks up a personal API token and records that it was used
+func (cfg *apiConfig) validateAPIToken(ctx context.Context, token string) (caller, error) {
+	apiToken, err := cfg.db.GetAPITokenByHash(ctx, auth.HashToken(token))
+	if err != nil {
+		return caller{}, err
+	}
+
+	err = cfg.db.UpdateAPITokenLastUsed(ctx, apiToken.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating API token last used time", "error", err)
+	}
+
+	return caller{
+		userID:		apiToken.UserID,
+		apiTokenID:	apiToken.ID,
+		scope:		apiToken.Scope,
+	}, nil
+}
+
+func (cfg *apiConfig) handlerAPITokensCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name		string	`json:"name"`
+		Scope		string	`json:"scope"`
+		// Omit for a token that never expires
+		ExpiresInDays	*int	`json:"expires_in_days"`
+	}
+
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
+		return
+	}
+
+	if strings.TrimSpace(params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Token name can't be empty", nil)
+		return
+	}
+	scopes, err := parseScope(params.Scope)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scope", err)
+		return
+	}
+
+	expiresAt := sql.NullTime{}
+	if params.ExpiresInDays != nil {
+		if *params.ExpiresInDays <= 0 {
+			respondWithError(w, http.StatusBadRequest, "expires_in_days must be positive", nil)
+			return
+		}
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, *params.ExpiresInDays), Valid: true}
+	}
+
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token", err)
+		return
+	}
+	token := apiTokenPrefix + secret
+
+	apiToken, err := cfg.db.CreateAPIToken(r.Context(), database.CreateAPITokenParams{
+		UserID:		caller.userID,
+		Name:		params.Name,
+		TokenHash:	auth.HashToken(token),
+		Scope:		strings.Join(scopes, " "),
+		ExpiresAt:	expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save token", err)
+		return
+	}
+
+	// Only a hash is stored, so this is the one chance to copy the token
+	response := apiTokenFromDB(apiToken)
+	response.Token = token
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handlerAPITokensList(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+
+	tokens, err := cfg.db.ListUserAPITokens(r.Context(), caller.userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tokens", err)
+		return
+	}
+
+	response := []APIToken{}
+	for _, token := range tokens {
+		response = append(response, apiTokenFromDB(token))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerAPITokensRevoke(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeAPIToken(r.Context(), database.RevokeAPITokenParams{
+		ID:		tokenID,
+		UserID:		caller.userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find token", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -11,7 +11,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pjjimiso/chirpy/internal/database"
-	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/entitlements"
+	"github.com/pjjimiso/chirpy/internal/webhooks"
 )
 
 type Chirp struct {
@@ -19,7 +20,8 @@ type Chirp struct {
 	CreatedAt	time.Time	`json:"created_at"`
 	UpdatedAt	time.Time	`json:"updated_at"`
 	CleanedBody	string		`json:"body"`
-	UserID		uuid.UUID	`json:"user_id"`
+	// Null for chirps kept after their author deleted their account
+	UserID		uuid.NullUUID	`json:"user_id"`
 }
 
 func (cfg *apiConfig) handlerChirpsGetAll(w http.ResponseWriter, r *http.Request) {
@@ -35,7 +37,7 @@ func (cfg *apiConfig) handlerChirpsGetAll(w http.ResponseWriter, r *http.Request
 			return
 		}
 
-		chirpsJSON, err = cfg.db.GetChirpsByAuthor(r.Context(), userID)
+		chirpsJSON, err = cfg.db.GetChirpsByAuthor(r.Context(), uuid.NullUUID{UUID: userID, Valid: true})
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, "No chirps by that author were found", err)
 			return
@@ -106,15 +108,13 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		Body	string		`json:"body"`
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil { 
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find token", err)
+	caller, ok := cfg.authenticate(w, r, scopeChirpsWrite)
+	if !ok {
 		return
 	}
+	userID := caller.userID
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil { 
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+	if !cfg.requireVerifiedEmail(w, r, userID) {
 		return
 	}
 
@@ -131,44 +131,156 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	plan, ok := cfg.userPlan(w, r, userID)
+	if !ok {
+		return
+	}
+
 	origMsg := params.Body
-	if len(origMsg) > 140 { 
-		respondWithError(w, http.StatusBadRequest, "Chirp exceeds 140 character limit", nil)
+	if len(origMsg) > plan.ChirpMaxLength { 
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chirp exceeds %d character limit", plan.ChirpMaxLength), nil)
 		return
 	}
 
+	if plan.ChirpsPerHour != entitlements.Unlimited {
+		count, err := cfg.db.CountUserChirpsSince(r.Context(), database.CountUserChirpsSinceParams{
+			UserID:		uuid.NullUUID{UUID: userID, Valid: true},
+			CreatedAt:	time.Now().Add(-time.Hour),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check chirp rate", err)
+			return
+		}
+		if count >= int64(plan.ChirpsPerHour) {
+			respondWithError(w, http.StatusTooManyRequests, fmt.Sprintf("Your plan allows %d chirps an hour", plan.ChirpsPerHour), nil)
+			return
+		}
+	}
+
 	cleanedMsg := cleanMessage(origMsg)
 	
-	chirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	chirp, err := qtx.CreateChirp(r.Context(), database.CreateChirpParams{
 		Body: cleanedMsg,
-		UserID: userID,
+		UserID: uuid.NullUUID{UUID: userID, Valid: true},
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
+	response := Chirp{
 		ID: chirp.ID,
 		CreatedAt: chirp.CreatedAt,
 		UpdatedAt: chirp.UpdatedAt,
 		CleanedBody: chirp.Body,
 		UserID: chirp.UserID,
-	})
+	}
+
+	err = recordEvent(r.Context(), qtx, webhooks.EventChirpCreated, userID, response)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record chirp event", err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
+		return
+	}
+	cfg.wakeOutboxRelay()
+
+	respondWithJSON(w, http.StatusCreated, response)
 }
 
-func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) { 
-	token, err := auth.GetBearerToken(r.Header)
+func (cfg *apiConfig) handlerChirpsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body	string		`json:"body"`
+	}
+
+	caller, ok := cfg.authenticate(w, r, scopeChirpsWrite)
+	if !ok {
+		return
+	}
+	userID := caller.userID
+
+	// Editing rewrites what's posted, so it's gated the same as posting
+	if !cfg.requireVerifiedEmail(w, r, userID) {
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil { 
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
 	if err != nil { 
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find token", err)
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+	if !chirp.UserID.Valid || chirp.UserID.UUID != userID { 
+		respondWithError(w, http.StatusForbidden, "You can't edit this chirp", nil)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	plan, ok := cfg.userPlan(w, r, userID)
+	if !ok {
+		return
+	}
+	if plan.EditWindow == 0 {
+		respondWithError(w, http.StatusForbidden, "Your plan doesn't include editing chirps", nil)
+		return
+	}
+	if time.Since(chirp.CreatedAt) > time.Duration(plan.EditWindow) {
+		respondWithError(w, http.StatusForbidden, "This chirp can no longer be edited", nil)
+		return
+	}
+	if len(params.Body) > plan.ChirpMaxLength { 
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chirp exceeds %d character limit", plan.ChirpMaxLength), nil)
+		return
+	}
+
+	chirp, err = cfg.db.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		ID:	chirpID,
+		UserID:	uuid.NullUUID{UUID: userID, Valid: true},
+		Body:	cleanMessage(params.Body),
+	})
 	if err != nil { 
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Chirp{
+		ID: chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		CleanedBody: chirp.Body,
+		UserID: chirp.UserID,
+	})
+}
+
+func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) { 
+	caller, ok := cfg.authenticate(w, r, scopeChirpsWrite)
+	if !ok {
 		return
 	}
+	userID := caller.userID
 
 	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil { 
@@ -182,20 +294,43 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if chirp.UserID != userID { 
+	if !chirp.UserID.Valid || chirp.UserID.UUID != userID { 
 		respondWithError(w, http.StatusForbidden, "You can't delete this chirp", nil)
 		return
 	}
 
-	err = cfg.db.DeleteChirp(r.Context(), database.DeleteChirpParams{
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.DeleteChirp(r.Context(), database.DeleteChirpParams{
 		ID:	chirpID,
-		UserID:	userID,
+		UserID:	uuid.NullUUID{UUID: userID, Valid: true},
 	})
 	if err != nil { 
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
 		return
 	}
 
+	err = recordEvent(r.Context(), qtx, webhooks.EventChirpDeleted, userID, map[string]uuid.UUID{
+		"id":		chirpID,
+		"user_id":	userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record chirp event", err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+	cfg.wakeOutboxRelay()
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+)
+
+const (
+	dataExportPurpose		= "data-export"
+	dataExportRetention		= 7 * 24 * time.Hour
+	dataExportDownloadExpiry	= 15 * time.Minute
+	dataExportBuildTimeout		= 5 * time.Minute
+	// Past the build timeout, a pending export was lost to a restart
+	dataExportStaleAfter		= 2 * dataExportBuildTimeout
+)
+
+type DataExport struct {
+	ID		uuid.UUID	`json:"id"`
+	Status		string		`json:"status"`
+	CreatedAt	time.Time	`json:"created_at"`
+	CompletedAt	*time.Time	`json:"completed_at,omitempty"`
+	ExpiresAt	*time.Time	`json:"expires_at,omitempty"`
+	// A short-lived link that works without an Authorization header, so
+	// the archive can be downloaded straight from a browser
+	DownloadURL	string		`json:"download_url,omitempty"`
+}
+
+func (cfg *apiConfig) handlerDataExportCreate(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+
+	// Failed exports are kept as long as a finished one would be
+	err := cfg.db.DeleteExpiredDataExports(r.Context(), time.Now().Add(-dataExportRetention))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't clean up old exports", err)
+		return
+	}
+
+	err = cfg.db.FailStaleDataExports(r.Context(), time.Now().Add(-dataExportStaleAfter))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't clean up old exports", err)
+		return
+	}
+
+	pending, err := cfg.db.HasPendingDataExport(r.Context(), caller.userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check exports", err)
+		return
+	}
+	if pending {
+		respondWithError(w, http.StatusConflict, "An export is already being prepared", nil)
+		return
+	}
+
+	export, err := cfg.db.CreateDataExport(r.Context(), caller.userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export", err)
+		return
+	}
+
+	cfg.goBackground(func() { cfg.buildDataExport(export.ID, caller.userID) })
+
+	respondWithJSON(w, http.StatusAccepted, DataExport{
+		ID:		export.ID,
+		Status:		export.Status,
+		CreatedAt:	export.CreatedAt,
+	})
+}
+
+// handlerDataExportGet reports an export's status to its owner, or serves the
+// archive itself when called with a download token.
+func (cfg *apiConfig) handlerDataExportGet(w http.ResponseWriter, r *http.Request) {
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID", err)
+		return
+	}
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		cfg.downloadDataExport(w, r, exportID, token)
+		return
+	}
+
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+
+	export, err := cfg.db.GetDataExport(r.Context(), exportID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && export.UserID != caller.userID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find export", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export", err)
+		return
+	}
+
+	response := DataExport{
+		ID:		export.ID,
+		Status:		export.Status,
+		CreatedAt:	export.CreatedAt,
+	}
+	if export.CompletedAt.Valid {
+		response.CompletedAt = &export.CompletedAt.Time
+	}
+	if export.ExpiresAt.Valid {
+		if time.Now().After(export.ExpiresAt.Time) {
+			respondWithError(w, http.StatusGone, "Export has expired, request a new one", nil)
+			return
+		}
+		response.ExpiresAt = &export.ExpiresAt.Time
+	}
+	if export.Status == "ready" {
+		token := auth.SignValue(cfg.jwtSecret, dataExportPurpose, export.ID.String(), time.Now().Add(dataExportDownloadExpiry))
+		response.DownloadURL = fmt.Sprintf("%s/api/users/me/export/%s?token=%s", cfg.baseURL, export.ID, url.QueryEscape(token))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) downloadDataExport(w http.ResponseWriter, r *http.Request, exportID uuid.UUID, token string) {
+	value, err := auth.VerifySignedValue(cfg.jwtSecret, dataExportPurpose, token)
+	if err != nil || value != exportID.String() {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired download link", err)
+		return
+	}
+
+	archive, err := cfg.db.GetDataExportArchive(r.Context(), exportID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find export", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+func (cfg *apiConfig) buildDataExport(exportID, userID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), dataExportBuildTimeout)
+	defer cancel()
+
+	archive, err := cfg.dataExportArchive(ctx, userID)
+	if err != nil {
+		slog.Error("Error building data export", "export_id", exportID, "error", err)
+		err = cfg.db.FailDataExport(ctx, exportID)
+		if err != nil {
+			slog.Error("Error marking data export failed", "export_id", exportID, "error", err)
+		}
+		return
+	}
+
+	err = cfg.db.CompleteDataExport(ctx, database.CompleteDataExportParams{
+		ID:		exportID,
+		Archive:	archive,
+		ExpiresAt:	sql.NullTime{Time: time.Now().Add(dataExportRetention), Valid: true},
+	})
+	if err != nil {
+		slog.Error("Error saving data export", "export_id", exportID, "error", err)
+	}
+}
+
+// dataExportArchive collects everything stored about a user into a ZIP of
+// JSON files. Secrets such as password hashes and token hashes are left out.
+// Chirpy doesn't store likes or media yet, so there are no files for them.
+// Anything new stored about a user needs a file here too.
+func (cfg *apiConfig) dataExportArchive(ctx context.Context, userID uuid.UUID) ([]byte, error) {
+	user, err := cfg.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	chirps, err := cfg.db.GetChirpsByAuthor(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := cfg.db.ListUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	apiTokens, err := cfg.db.ListUserAPITokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := cfg.db.ListUserOAuthClients(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := cfg.db.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	currentSubscription, err := cfg.db.GetSubscription(ctx, userID)
+	hasSubscription := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	periods, err := cfg.db.ListSubscriptionPeriods(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	owner := uuid.NullUUID{UUID: userID, Valid: true}
+	endpoints, err := cfg.db.ListWebhookEndpoints(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := cfg.db.ListUserWebhookDeliveries(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	type profile struct {
+		ID		uuid.UUID	`json:"id"`
+		Email		string		`json:"email"`
+		PendingEmail	string		`json:"pending_email,omitempty"`
+		EmailVerified	bool		`json:"email_verified"`
+		IsChirpyRed	bool		`json:"is_chirpy_red"`
+		TwoFactor	bool		`json:"two_factor_enabled"`
+		CreatedAt	time.Time	`json:"created_at"`
+		UpdatedAt	time.Time	`json:"updated_at"`
+	}
+	type session struct {
+		CreatedAt	time.Time	`json:"created_at"`
+		LastUsedAt	time.Time	`json:"last_used_at"`
+		ExpiresAt	time.Time	`json:"expires_at"`
+		RevokedAt	*time.Time	`json:"revoked_at"`
+		ClientID	string		`json:"client_id,omitempty"`
+		Scope		string		`json:"scope,omitempty"`
+	}
+	type oauthClient struct {
+		ClientID	string		`json:"client_id"`
+		Name		string		`json:"name"`
+		RedirectURIs	[]string	`json:"redirect_uris"`
+		Scope		string		`json:"scope"`
+		CreatedAt	time.Time	`json:"created_at"`
+	}
+	type identity struct {
+		Issuer		string		`json:"issuer"`
+		Subject		string		`json:"subject"`
+		CreatedAt	time.Time	`json:"created_at"`
+	}
+	type period struct {
+		StartsAt	time.Time	`json:"starts_at"`
+		EndsAt		time.Time	`json:"ends_at"`
+		EventID		string		`json:"event_id,omitempty"`
+	}
+	type subscription struct {
+		Status			string		`json:"status"`
+		CurrentPeriodStart	time.Time	`json:"current_period_start"`
+		CurrentPeriodEnd	time.Time	`json:"current_period_end"`
+		Periods			[]period	`json:"periods"`
+	}
+	type webhookDelivery struct {
+		WebhookDelivery
+		EndpointID	uuid.UUID	`json:"endpoint_id"`
+	}
+
+	type file struct {
+		name	string
+		data	any
+	}
+	files := []file{}
+
+	files = append(files, file{"profile.json", profile{
+		ID:		user.ID,
+		Email:		user.Email,
+		PendingEmail:	user.PendingEmail.String,
+		EmailVerified:	user.EmailVerified,
+		IsChirpyRed:	user.IsChirpyRed,
+		TwoFactor:	user.TotpEnabled,
+		CreatedAt:	user.CreatedAt,
+		UpdatedAt:	user.UpdatedAt,
+	}})
+
+	chirpsJSON := []Chirp{}
+	for _, chirp := range chirps {
+		chirpsJSON = append(chirpsJSON, Chirp{
+			ID:		chirp.ID,
+			CreatedAt:	chirp.CreatedAt,
+			UpdatedAt:	chirp.UpdatedAt,
+			CleanedBody:	chirp.Body,
+			UserID:		chirp.UserID,
+		})
+	}
+	files = append(files, file{"chirps.json", chirpsJSON})
+
+	sessionsJSON := []session{}
+	for _, s := range sessions {
+		item := session{
+			CreatedAt:	s.CreatedAt,
+			LastUsedAt:	s.UpdatedAt,
+			ExpiresAt:	s.ExpiresAt,
+			ClientID:	s.ClientID.String,
+			Scope:		s.Scope.String,
+		}
+		if s.RevokedAt.Valid {
+			item.RevokedAt = &s.RevokedAt.Time
+		}
+		sessionsJSON = append(sessionsJSON, item)
+	}
+	files = append(files, file{"sessions.json", sessionsJSON})
+
+	apiTokensJSON := []APIToken{}
+	for _, token := range apiTokens {
+		apiTokensJSON = append(apiTokensJSON, apiTokenFromDB(token))
+	}
+	files = append(files, file{"api_tokens.json", apiTokensJSON})
+
+	clientsJSON := []oauthClient{}
+	for _, client := range clients {
+		clientsJSON = append(clientsJSON, oauthClient{
+			ClientID:	client.ID,
+			Name:		client.Name,
+			RedirectURIs:	client.RedirectUris,
+			Scope:		client.Scopes,
+			CreatedAt:	client.CreatedAt,
+		})
+	}
+	files = append(files, file{"oauth_clients.json", clientsJSON})
+
+	identitiesJSON := []identity{}
+	for _, i := range identities {
+		identitiesJSON = append(identitiesJSON, identity{
+			Issuer:		i.Issuer,
+			Subject:	i.Subject,
+			CreatedAt:	i.CreatedAt,
+		})
+	}
+	files = append(files, file{"linked_identities.json", identitiesJSON})
+
+	// Periods outlive a subscription's current state, so they're exported
+	// even without one
+	var subscriptionJSON *subscription
+	if hasSubscription || len(periods) > 0 {
+		subscriptionJSON = &subscription{Periods: []period{}}
+		if hasSubscription {
+			subscriptionJSON.Status = currentSubscription.Status
+			subscriptionJSON.CurrentPeriodStart = currentSubscription.CurrentPeriodStart
+			subscriptionJSON.CurrentPeriodEnd = currentSubscription.CurrentPeriodEnd
+		}
+		for _, p := range periods {
+			subscriptionJSON.Periods = append(subscriptionJSON.Periods, period{
+				StartsAt:	p.StartsAt,
+				EndsAt:		p.EndsAt,
+				EventID:	p.EventID.String,
+			})
+		}
+	}
+	files = append(files, file{"subscription.json", subscriptionJSON})
+
+	// Signing secrets are left out, like every other secret
+	endpointsJSON := []WebhookEndpoint{}
+	for _, endpoint := range endpoints {
+		endpointsJSON = append(endpointsJSON, webhookEndpointFromDB(endpoint))
+	}
+	files = append(files, file{"webhook_endpoints.json", endpointsJSON})
+
+	deliveriesJSON := []webhookDelivery{}
+	for _, delivery := range deliveries {
+		item := webhookDelivery{
+			WebhookDelivery:	webhookDeliveryFromDB(delivery),
+			EndpointID:		delivery.EndpointID,
+		}
+		item.Payload = json.RawMessage(delivery.Payload)
+		deliveriesJSON = append(deliveriesJSON, item)
+	}
+	files = append(files, file{"webhook_deliveries.json", deliveriesJSON})
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
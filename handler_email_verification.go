@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/mailer"
+)
+
+const (
+	emailVerificationPurpose	= "verify-email"
+	emailVerificationExpiry		= 24 * time.Hour
+)
+
+// validateEmail accepts a bare address only, no display name or comments
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("invalid email address")
+	}
+	return nil
+}
+
+// sendVerificationEmail mails a signed link that confirms the user owns email.
+// The link is bound to the address, so it stops working if the user changes
+// their email again before clicking it.
+func (cfg *apiConfig) sendVerificationEmail(userID uuid.UUID, email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+	defer cancel()
+
+	token := auth.SignValue(cfg.jwtSecret, emailVerificationPurpose, userID.String() + "|" + email, time.Now().Add(emailVerificationExpiry))
+	link := fmt.Sprintf("%s/api/users/verify-email?token=%s", cfg.baseURL, url.QueryEscape(token))
+
+	err := cfg.mailer.Send(ctx, mailer.Message{
+		To:		email,
+		Subject:	"Verify your Chirpy email address",
+		Body:		fmt.Sprintf("Confirm this is your email address by opening the link below within 24 hours:\n%s\n\nIf you didn't sign up for Chirpy, you can ignore this email.", link),
+	})
+	if err != nil {
+		slog.Error("Error sending verification email", "user_id", userID, "error", err)
+	}
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Email		string	`json:"email"`
+		EmailVerified	bool	`json:"email_verified"`
+	}
+
+	value, err := auth.VerifySignedValue(cfg.jwtSecret, emailVerificationPurpose, r.URL.Query().Get("token"))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired verification link", err)
+		return
+	}
+
+	id, email, _ := strings.Cut(value, "|")
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired verification link", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+
+	switch {
+	case user.Email == email:
+		_, err = cfg.db.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+			ID:	userID,
+			Email:	email,
+		})
+	case user.PendingEmail.Valid && user.PendingEmail.String == email:
+		// The old address stays active until now
+		_, err = cfg.db.ConfirmUserPendingEmail(r.Context(), database.ConfirmUserPendingEmailParams{
+			ID:		userID,
+			PendingEmail:	user.PendingEmail,
+		})
+	default:
+		respondWithError(w, http.StatusUnauthorized, "Verification link is no longer valid", nil)
+		return
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		respondWithError(w, http.StatusConflict, "Email is already in use", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Email:		email,
+		EmailVerified:	true,
+	})
+}
+
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+	userID := caller.userID
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+
+	var target string
+	switch {
+	case user.PendingEmail.Valid:
+		target = user.PendingEmail.String
+	case !user.EmailVerified:
+		target = user.Email
+	default:
+		respondWithError(w, http.StatusConflict, "Email is already verified", nil)
+		return
+	}
+
+	if !cfg.emailRequestAllowed(w, r, target) {
+		return
+	}
+	cfg.goBackground(func() { cfg.sendVerificationEmail(userID, target) })
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// requireVerifiedEmail reports whether the user may use features gated on a
+// confirmed address, responding with an error if not
+func (cfg *apiConfig) requireVerifiedEmail(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return false
+	}
+	if !user.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Verify your email address first", nil)
+		return false
+	}
+	return true
+}
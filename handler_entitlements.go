@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/entitlements"
+)
+
+// userPlanName is the entitlements lookup. Chirpy Red is the only paid plan
+// so far, and is_chirpy_red is kept in step with the subscription.
+func (cfg *apiConfig) userPlanName(ctx context.Context, userID uuid.UUID) (string, error) {
+	user, err := cfg.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if user.IsChirpyRed {
+		return entitlements.PlanChirpyRed, nil
+	}
+	return entitlements.PlanFree, nil
+}
+
+// userPlan gets the caller's plan, responding with an error if it can't
+func (cfg *apiConfig) userPlan(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (entitlements.Plan, bool) {
+	plan, err := cfg.entitlements.For(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return entitlements.Plan{}, false
+	}
+	return plan, true
+}
+
+func (cfg *apiConfig) handlerEntitlementsGet(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, scopeChirpsRead)
+	if !ok {
+		return
+	}
+
+	plan, ok := cfg.userPlan(w, r, caller.userID)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, plan)
+}
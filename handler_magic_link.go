@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/mailer"
+)
+
+const (
+	magicLinkPurpose	= "magic-link"
+	magicLinkExpiry		= 15 * time.Minute
+	// Holds a nonce tying the link to the device that asked for it, so a
+	// link forwarded or intercepted from the mailbox can't be used elsewhere
+	magicLinkCookie		= "__Host-chirpy_magic"
+)
+
+func (cfg *apiConfig) handlerMagicLinkRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email		string	`json:"email"`
+		SessionMode	string	`json:"session_mode"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
+		return
+	}
+	if params.SessionMode != "" && params.SessionMode != "cookie" {
+		respondWithError(w, http.StatusBadRequest, "session_mode must be cookie or omitted", nil)
+		return
+	}
+
+	if !cfg.emailRequestAllowed(w, r, params.Email) {
+		return
+	}
+
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login nonce", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:		magicLinkCookie,
+		Value:		nonce,
+		Path:		"/",
+		MaxAge:		int(magicLinkExpiry.Seconds()),
+		HttpOnly:	true,
+		Secure:		true,
+		// Lax, since following the link from a mail client is a cross-site navigation
+		SameSite:	http.SameSiteLaxMode,
+	})
+
+	// Like password resets, the lookup and email happen in the background
+	// so the response doesn't reveal whether the account exists
+	cfg.goBackground(func() { cfg.sendMagicLink(params.Email, nonce, params.SessionMode) })
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) sendMagicLink(email, nonce, sessionMode string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+	defer cancel()
+
+	user, err := cfg.db.GetUser(ctx, email)
+	if err != nil {
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.Error("Error creating magic link token", "error", err)
+		return
+	}
+
+	expiresAt := time.Now().Add(magicLinkExpiry)
+	err = cfg.db.CreateMagicLinkToken(ctx, database.CreateMagicLinkTokenParams{
+		TokenHash:	auth.HashToken(token),
+		UserID:		user.ID,
+		NonceHash:	auth.HashToken(nonce),
+		ExpiresAt:	expiresAt,
+	})
+	if err != nil {
+		slog.Error("Error saving magic link token", "error", err)
+		return
+	}
+
+	signed := auth.SignValue(cfg.jwtSecret, magicLinkPurpose, token + "|" + sessionMode, expiresAt)
+	link := fmt.Sprintf("%s/api/login/magic-link/verify?token=%s", cfg.baseURL, url.QueryEscape(signed))
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:		user.Email,
+		Subject:	"Your Chirpy login link",
+		Body:		fmt.Sprintf("Use this link within %d minutes to log in to Chirpy. It only works once, in the browser you requested it from:\n%s\n\nIf this wasn't you, you can ignore this email.", int(magicLinkExpiry.Minutes()), link),
+	})
+	if err != nil {
+		slog.Error("Error sending magic link email", "error", err)
+	}
+}
+
+func (cfg *apiConfig) handlerMagicLinkVerify(w http.ResponseWriter, r *http.Request) {
+	value, err := auth.VerifySignedValue(cfg.jwtSecret, magicLinkPurpose, r.URL.Query().Get("token"))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login link", err)
+		return
+	}
+	token, sessionMode, _ := strings.Cut(value, "|")
+
+	cookie, err := r.Cookie(magicLinkCookie)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Open the login link in the browser you requested it from", err)
+		return
+	}
+
+	// Only consumed when the nonce matches, so a failed attempt from another
+	// device doesn't burn the link for the real one
+	userID, err := cfg.db.ConsumeMagicLinkToken(r.Context(), database.ConsumeMagicLinkTokenParams{
+		TokenHash:	auth.HashToken(token),
+		NonceHash:	auth.HashToken(cookie.Value),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login link", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:		magicLinkCookie,
+		Value:		"",
+		Path:		"/",
+		MaxAge:		-1,
+		HttpOnly:	true,
+		Secure:		true,
+		SameSite:	http.SameSiteLaxMode,
+	})
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	// Clicking the link proves the user controls the address
+	if !user.EmailVerified {
+		_, err = cfg.db.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+			ID:	user.ID,
+			Email:	user.Email,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+			return
+		}
+		user.EmailVerified = true
+	}
+
+	if user.TotpEnabled {
+		cfg.respondWithMFAChallenge(w, user.ID, sessionMode == "cookie")
+		return
+	}
+	cfg.respondWithSession(w, r, user, time.Hour, sessionMode == "cookie")
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+)
+
+const (
+	authorizationCodeExpiry	= 10 * time.Minute
+	oauthAccessTokenExpiry	= time.Hour
+)
+
+type OAuthClient struct {
+	ClientID	string		`json:"client_id"`
+	ClientSecret	string		`json:"client_secret,omitempty"`
+	Name		string		`json:"name"`
+	RedirectURIs	[]string	`json:"redirect_uris"`
+	Scope		string		`json:"scope"`
+	CreatedAt	time.Time	`json:"created_at"`
+}
+
+func (cfg *apiConfig) handlerOAuthClientsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name		string		`json:"name"`
+		RedirectURIs	[]string	`json:"redirect_uris"`
+		Scope		string		`json:"scope"`
+		// Public clients such as mobile apps can't keep a secret and rely
+		// on PKCE alone
+		Confidential	bool		`json:"confidential"`
+	}
+
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
+		return
+	}
+
+	if strings.TrimSpace(params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Client name can't be empty", nil)
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one redirect URI is required", nil)
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid redirect URI", err)
+			return
+		}
+	}
+	scopes, err := parseScope(params.Scope)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scope", err)
+		return
+	}
+
+	clientID, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create client ID", err)
+		return
+	}
+	clientID = "chirpy_" + clientID[:32]
+
+	var secret string
+	var secretHash sql.NullString
+	if params.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create client secret", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.db.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		ID:		clientID,
+		UserID:		caller.userID,
+		Name:		params.Name,
+		SecretHash:	secretHash,
+		RedirectUris:	params.RedirectURIs,
+		Scopes:		strings.Join(scopes, " "),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create client", err)
+		return
+	}
+
+	// The secret is only ever shown here
+	respondWithJSON(w, http.StatusCreated, OAuthClient{
+		ClientID:	client.ID,
+		ClientSecret:	secret,
+		Name:		client.Name,
+		RedirectURIs:	client.RedirectUris,
+		Scope:		client.Scopes,
+		CreatedAt:	client.CreatedAt,
+	})
+}
+
+// validateRedirectURI requires an absolute https URL, except on loopback
+// addresses where plain http is allowed for native apps and development
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if u.Fragment != "" {
+		return errors.New("redirect URI can't contain a fragment")
+	}
+	switch {
+	case u.Scheme == "https" && u.Host != "":
+		return nil
+	case u.Scheme == "http" && (u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1" || u.Hostname() == "::1"):
+		return nil
+	}
+	return errors.New("redirect URI must use https")
+}
+
+// authorizeRequest holds the parameters of an authorization request, carried
+// through the consent form in hidden fields
+type authorizeRequest struct {
+	ClientID		string
+	RedirectURI		string
+	Scope			string
+	State			string
+	CodeChallenge		string
+	CodeChallengeMethod	string
+}
+
+func parseAuthorizeRequest(values url.Values) authorizeRequest {
+	return authorizeRequest{
+		ClientID:		values.Get("client_id"),
+		RedirectURI:		values.Get("redirect_uri"),
+		Scope:			values.Get("scope"),
+		State:			values.Get("state"),
+		CodeChallenge:		values.Get("code_challenge"),
+		CodeChallengeMethod:	values.Get("code_challenge_method"),
+	}
+}
+
+// validateAuthorizeRequest checks the request against the registered client.
+// Until the redirect URI is known to be good, errors are shown to the user
+// rather than redirected, so Chirpy can't be used as an open redirector.
+func (cfg *apiConfig) validateAuthorizeRequest(w http.ResponseWriter, r *http.Request, req authorizeRequest) (database.OauthClient, []string, bool) {
+	client, err := cfg.db.GetOAuthClient(r.Context(), req.ClientID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown client", err)
+		return database.OauthClient{}, nil, false
+	}
+	if !slices.Contains(client.RedirectUris, req.RedirectURI) {
+		respondWithError(w, http.StatusBadRequest, "Redirect URI isn't registered for this client", nil)
+		return database.OauthClient{}, nil, false
+	}
+
+	if r.Method == http.MethodGet && r.URL.Query().Get("response_type") != "code" {
+		redirectWithOAuthError(w, r, req, "unsupported_response_type", "Only the code response type is supported")
+		return database.OauthClient{}, nil, false
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		redirectWithOAuthError(w, r, req, "invalid_request", "PKCE with the S256 method is required")
+		return database.OauthClient{}, nil, false
+	}
+
+	scopes, err := parseScope(req.Scope)
+	if err != nil {
+		redirectWithOAuthError(w, r, req, "invalid_scope", err.Error())
+		return database.OauthClient{}, nil, false
+	}
+	allowed := strings.Fields(client.Scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			redirectWithOAuthError(w, r, req, "invalid_scope", "Client isn't registered for scope " + scope)
+			return database.OauthClient{}, nil, false
+		}
+	}
+	return client, scopes, true
+}
+
+func redirectWithOAuthError(w http.ResponseWriter, r *http.Request, req authorizeRequest, code, description string) {
+	params := url.Values{}
+	params.Set("error", code)
+	params.Set("error_description", description)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	http.Redirect(w, r, appendQuery(req.RedirectURI, params), http.StatusFound)
+}
+
+func appendQuery(uri string, params url.Values) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + params.Encode()
+	}
+	return uri + "?" + params.Encode()
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<html>
+  <body>
+    <h1>Authorize {{.ClientName}}</h1>
+    <p>{{.ClientName}} would like to:</p>
+    <ul>
+      {{range .Scopes}}<li>{{.}}</li>{{end}}
+    </ul>
+    {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+    <form method="POST" action="/oauth/authorize">
+      <input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+      <input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+      <input type="hidden" name="scope" value="{{.Request.Scope}}">
+      <input type="hidden" name="state" value="{{.Request.State}}">
+      <input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+      <input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+      <p><label>Email <input type="email" name="email" required></label></p>
+      <p><label>Password <input type="password" name="password" required></label></p>
+      <p><label>Two-factor code (if enabled) <input type="text" name="otp" autocomplete="one-time-code"></label></p>
+      <button type="submit" name="decision" value="approve">Allow</button>
+      <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+    </form>
+  </body>
+</html>
+`))
+
+func renderConsentPage(w http.ResponseWriter, status int, client database.OauthClient, scopes []string, req authorizeRequest, errMsg string) {
+	descriptions := []string{}
+	for _, scope := range scopes {
+		descriptions = append(descriptions, oauthScopes[scope])
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The consent page must never be framed, or users could be tricked
+	// into clicking Allow
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	consentTemplate.Execute(w, struct {
+		ClientName	string
+		Scopes		[]string
+		Request		authorizeRequest
+		Error		string
+	}{
+		ClientName:	client.Name,
+		Scopes:		descriptions,
+		Request:	req,
+		Error:		errMsg,
+	})
+}
+
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	req := parseAuthorizeRequest(r.URL.Query())
+	client, scopes, ok := cfg.validateAuthorizeRequest(w, r, req)
+	if !ok {
+		return
+	}
+	renderConsentPage(w, http.StatusOK, client, scopes, req, "")
+}
+
+func (cfg *apiConfig) handlerOAuthAuthorizeDecision(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+		return
+	}
+
+	req := parseAuthorizeRequest(r.PostForm)
+	client, scopes, ok := cfg.validateAuthorizeRequest(w, r, req)
+	if !ok {
+		return
+	}
+
+	if r.PostForm.Get("decision") != "approve" {
+		redirectWithOAuthError(w, r, req, "access_denied", "The user denied the request")
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	accountKey := accountThrottleKey(email)
+	if cfg.loginAttempt(r, accountKey) > 0 {
+		renderConsentPage(w, http.StatusTooManyRequests, client, scopes, req, "Too many login attempts, try again later")
+		return
+	}
+
+	user, err := cfg.checkCredentials(r, accountKey, email, r.PostForm.Get("password"))
+	if errors.Is(err, errInvalidCredentials) {
+		renderConsentPage(w, http.StatusUnauthorized, client, scopes, req, "Incorrect email or password")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	if user.TotpEnabled {
+		throttleKey := "mfa:" + user.ID.String()
+		if cfg.loginAttempt(r, throttleKey) > 0 {
+			renderConsentPage(w, http.StatusTooManyRequests, client, scopes, req, "Too many login attempts, try again later")
+			return
+		}
+		valid, err := cfg.useTOTPCode(r, user, r.PostForm.Get("otp"))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't validate code", err)
+			return
+		}
+		if !valid {
+			renderConsentPage(w, http.StatusUnauthorized, client, scopes, req, "Invalid two-factor code")
+			return
+		}
+		cfg.loginSucceeded(r, throttleKey)
+	}
+
+	// Signing in to approve a client counts as logging in, like any other
+	if user.DeleteAfter.Valid {
+		err = cfg.cancelPendingDeletion(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't cancel account deletion", err)
+			return
+		}
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create authorization code", err)
+		return
+	}
+
+	err = cfg.db.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:	auth.HashToken(code),
+		ClientID:	client.ID,
+		UserID:		user.ID,
+		RedirectUri:	req.RedirectURI,
+		Scope:		strings.Join(scopes, " "),
+		CodeChallenge:	req.CodeChallenge,
+		ExpiresAt:	time.Now().Add(authorizationCodeExpiry),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save authorization code", err)
+		return
+	}
+
+	params := url.Values{}
+	params.Set("code", code)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	http.Redirect(w, r, appendQuery(req.RedirectURI, params), http.StatusFound)
+}
+
+// respondWithOAuthError writes an error in the RFC 6749 section 5.2 format
+func respondWithOAuthError(w http.ResponseWriter, code int, oauthCode, description string, err error) {
+	type errorResponse struct {
+		Error			string	`json:"error"`
+		ErrorDescription	string	`json:"error_description,omitempty"`
+		RequestID		string	`json:"request_id,omitempty"`
+	}
+
+	if err != nil {
+		respondWithError(w, code, description, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, errorResponse{
+		Error:			oauthCode,
+		ErrorDescription:	description,
+		RequestID:		w.Header().Get(requestIDHeader),
+	})
+}
+
+// authenticateOAuthClient identifies the client calling a token endpoint,
+// from HTTP Basic credentials or client_id and client_secret form fields.
+// Confidential clients must present their secret.
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := cfg.db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, errors.New("unknown client")
+	}
+
+	if client.SecretHash.Valid {
+		if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+			return database.OauthClient{}, errors.New("invalid client secret")
+		}
+	}
+	return client, nil
+}
+
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		AccessToken	string	`json:"access_token"`
+		TokenType	string	`json:"token_type"`
+		ExpiresIn	int	`json:"expires_in"`
+		RefreshToken	string	`json:"refresh_token"`
+		Scope		string	`json:"scope"`
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Couldn't parse form", nil)
+		return
+	}
+
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", err.Error(), nil)
+		return
+	}
+
+	var session database.RefreshToken
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := cfg.db.ConsumeOAuthAuthorizationCode(r.Context(), auth.HashToken(r.PostForm.Get("code")))
+		if err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired authorization code", nil)
+			return
+		}
+		if code.ClientID != client.ID || code.RedirectUri != r.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was issued to another client or redirect URI", nil)
+			return
+		}
+		if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Code verifier doesn't match the code challenge", nil)
+			return
+		}
+
+		refreshToken, err := auth.MakeRefreshToken()
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't create refresh token", err)
+			return
+		}
+		session, err = cfg.db.CreateClientRefreshToken(r.Context(), database.CreateClientRefreshTokenParams{
+			Token:		refreshToken,
+			UserID:		code.UserID,
+			ExpiresAt:	time.Now().AddDate(0, 0, 60),
+			ClientID:	sql.NullString{String: client.ID, Valid: true},
+			Scope:		sql.NullString{String: code.Scope, Valid: true},
+		})
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't create session", err)
+			return
+		}
+
+	case "refresh_token":
+		token, err := cfg.db.GetUserFromRefreshToken(r.Context(), r.PostForm.Get("refresh_token"))
+		if err != nil || !token.ClientID.Valid || token.ClientID.String != client.ID || time.Now().After(token.ExpiresAt) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired refresh token", nil)
+			return
+		}
+
+		// The new access token replaces the one previously issued for this session
+		err = cfg.denySessionAccessToken(r.Context(), token.AccessTokenJti, token.AccessTokenExpiresAt)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't revoke previous access token", err)
+			return
+		}
+		session = database.RefreshToken{
+			Token:		token.Token,
+			UserID:		token.UserID,
+			ClientID:	token.ClientID,
+			Scope:		token.Scope,
+		}
+
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Grant type must be authorization_code or refresh_token", nil)
+		return
+	}
+
+	accessToken, err := cfg.makeSessionAccessToken(r.Context(), session.UserID, session.Token, client.ID, session.Scope.String, oauthAccessTokenExpiry)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't create access token", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		AccessToken:	accessToken,
+		TokenType:	"Bearer",
+		ExpiresIn:	int(oauthAccessTokenExpiry.Seconds()),
+		RefreshToken:	session.Token,
+		Scope:		session.Scope.String,
+	})
+}
+
+type tokenIntrospection struct {
+	Active		bool	`json:"active"`
+	Scope		string	`json:"scope,omitempty"`
+	ClientID	string	`json:"client_id,omitempty"`
+	Sub		string	`json:"sub,omitempty"`
+	Exp		int64	`json:"exp,omitempty"`
+	Iat		int64	`json:"iat,omitempty"`
+	TokenType	string	`json:"token_type,omitempty"`
+}
+
+// introspectToken describes any token Chirpy issues. The kind of token is
+// clear from its format, so callers' token_type_hint isn't needed.
+func (cfg *apiConfig) introspectToken(ctx context.Context, token string) tokenIntrospection {
+	inactive := tokenIntrospection{Active: false}
+
+	switch {
+	case strings.HasPrefix(token, apiTokenPrefix):
+		apiToken, err := cfg.db.GetAPITokenByHash(ctx, auth.HashToken(token))
+		if err != nil {
+			return inactive
+		}
+		t := tokenIntrospection{
+			Active:		true,
+			Scope:		apiToken.Scope,
+			Sub:		apiToken.UserID.String(),
+			Iat:		apiToken.CreatedAt.Unix(),
+			TokenType:	"api_token",
+		}
+		if apiToken.ExpiresAt.Valid {
+			t.Exp = apiToken.ExpiresAt.Time.Unix()
+		}
+		return t
+
+	case strings.Count(token, ".") == 2:
+		claims, err := cfg.validateAccessToken(ctx, token)
+		if err != nil {
+			return inactive
+		}
+		return tokenIntrospection{
+			Active:		true,
+			Scope:		claims.Scope,
+			ClientID:	claims.ClientID,
+			Sub:		claims.Subject,
+			Exp:		claims.ExpiresAt.Unix(),
+			Iat:		claims.IssuedAt.Unix(),
+			TokenType:	"access_token",
+		}
+
+	default:
+		session, err := cfg.db.GetUserFromRefreshToken(ctx, token)
+		if err != nil || session.RevokedAt.Valid || time.Now().After(session.ExpiresAt) {
+			return inactive
+		}
+		return tokenIntrospection{
+			Active:		true,
+			Scope:		session.Scope.String,
+			ClientID:	session.ClientID.String,
+			Sub:		session.UserID.String(),
+			Exp:		session.ExpiresAt.Unix(),
+			TokenType:	"refresh_token",
+		}
+	}
+}
+
+func (cfg *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Couldn't parse form", nil)
+		return
+	}
+
+	// Only confidential clients can prove who they are, so public ones
+	// can't look up tokens
+	client, err := cfg.authenticateOAuthClient(r)
+	if err == nil && !client.SecretHash.Valid {
+		err = errors.New("client has no secret")
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, cfg.introspectToken(r.Context(), r.PostForm.Get("token")))
+}
+
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Couldn't parse form", nil)
+		return
+	}
+
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", err.Error(), nil)
+		return
+	}
+
+	// RFC 7009 treats unknown and already revoked tokens as success, since
+	// either way the client's goal has been met
+	token := r.PostForm.Get("token")
+	if strings.Count(token, ".") == 2 {
+		claims, err := cfg.validateAccessToken(r.Context(), token)
+		if err != nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if claims.ClientID != client.ID {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Token wasn't issued to this client", nil)
+			return
+		}
+		err = cfg.denylist.Deny(r.Context(), claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusServiceUnavailable, "server_error", "Couldn't revoke access token", err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	session, err := cfg.db.GetUserFromRefreshToken(r.Context(), token)
+	if err != nil || session.RevokedAt.Valid {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if session.ClientID.String != client.ID {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Token wasn't issued to this client", nil)
+		return
+	}
+
+	// Revoking a refresh token also ends the access token issued from it
+	err = cfg.db.UpdateTokenRevokedAt(r.Context(), session.Token)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusServiceUnavailable, "server_error", "Couldn't revoke session", err)
+		return
+	}
+	err = cfg.denySessionAccessToken(r.Context(), session.AccessTokenJti, session.AccessTokenExpiresAt)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusServiceUnavailable, "server_error", "Couldn't revoke access token", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
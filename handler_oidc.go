@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/oidc"
+)
+
+const (
+	oidcStateCookie		= "__Host-chirpy_oidc"
+	oidcStatePurpose	= "oidc-login"
+	oidcLoginExpiry		= 10 * time.Minute
+	// Signing in again with a provider proves who the user is for actions
+	// that need more than a session, like deleting an account
+	oidcPurposeReauth	= "reauth"
+	reauthCookie		= "__Host-chirpy_reauth"
+	reauthAudience		= "chirpy-reauth"
+	reauthExpiry		= 5 * time.Minute
+)
+
+var (
+	errOIDCEmailUnverified	= errors.New("identity provider hasn't verified the email address")
+	errOIDCAccountConflict	= errors.New("an unverified account already uses this email address")
+)
+
+// handlerOIDCLogin sends the user to the identity provider. The state, nonce
+// and PKCE verifier are kept in a signed cookie until they come back. With
+// purpose=reauth the user isn't logged in, they get a short-lived token
+// proving they just signed in instead.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := cfg.oidcProviders[name]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	sessionMode := r.URL.Query().Get("session_mode")
+	if sessionMode != "" && sessionMode != "cookie" {
+		respondWithError(w, http.StatusBadRequest, "session_mode must be cookie or omitted", nil)
+		return
+	}
+	purpose := r.URL.Query().Get("purpose")
+	if purpose != "" && purpose != oidcPurposeReauth {
+		respondWithError(w, http.StatusBadRequest, "purpose must be reauth or omitted", nil)
+		return
+	}
+
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login state", err)
+		return
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login state", err)
+		return
+	}
+	verifier, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login state", err)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, auth.PKCEChallenge(verifier))
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach identity provider", err)
+		return
+	}
+
+	value := strings.Join([]string{name, state, nonce, verifier, sessionMode, purpose}, "|")
+	http.SetCookie(w, &http.Cookie{
+		Name:		oidcStateCookie,
+		Value:		auth.SignValue(cfg.jwtSecret, oidcStatePurpose, value, time.Now().Add(oidcLoginExpiry)),
+		Path:		"/",
+		MaxAge:		int(oidcLoginExpiry.Seconds()),
+		HttpOnly:	true,
+		Secure:		true,
+		// Lax, since the provider's redirect back here is a cross-site navigation
+		SameSite:	http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := cfg.oidcProviders[name]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login session not found, start again", err)
+		return
+	}
+	// The state is single use
+	http.SetCookie(w, &http.Cookie{
+		Name:		oidcStateCookie,
+		Value:		"",
+		Path:		"/",
+		MaxAge:		-1,
+		HttpOnly:	true,
+		Secure:		true,
+		SameSite:	http.SameSiteLaxMode,
+	})
+
+	value, err := auth.VerifySignedValue(cfg.jwtSecret, oidcStatePurpose, cookie.Value)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login session expired, start again", err)
+		return
+	}
+	parts := strings.Split(value, "|")
+	if len(parts) != 6 || parts[0] != name {
+		respondWithError(w, http.StatusBadRequest, "Login session expired, start again", nil)
+		return
+	}
+	state, nonce, verifier, sessionMode, purpose := parts[1], parts[2], parts[3], parts[4], parts[5]
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login state doesn't match", nil)
+		return
+	}
+	if query.Get("error") != "" {
+		respondWithError(w, http.StatusUnauthorized, "Identity provider didn't authorize the login: " + query.Get("error"), nil)
+		return
+	}
+
+	idToken, err := provider.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't verify login with identity provider", err)
+		return
+	}
+
+	if purpose == oidcPurposeReauth {
+		cfg.respondWithReauth(w, r, idToken, sessionMode == "cookie")
+		return
+	}
+
+	user, err := cfg.userForIdentity(r.Context(), idToken)
+	if errors.Is(err, errOIDCEmailUnverified) {
+		respondWithError(w, http.StatusForbidden, "Your identity provider hasn't verified your email address", err)
+		return
+	}
+	if errors.Is(err, errOIDCAccountConflict) {
+		respondWithError(w, http.StatusConflict, "An account with this email exists but isn't verified, log in with your password and verify it first", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	// The callback is a top-level navigation, so cookie sessions go back to
+	// the app instead of getting a JSON page
+	if sessionMode == "cookie" {
+		if user.TotpEnabled {
+			cfg.redirectWithMFAChallenge(w, r, user.ID)
+			return
+		}
+		cfg.redirectWithSession(w, r, user)
+		return
+	}
+	if user.TotpEnabled {
+		cfg.respondWithMFAChallenge(w, user.ID, false)
+		return
+	}
+	cfg.respondWithSession(w, r, user, time.Hour, false)
+}
+
+// respondWithReauth issues a token proving the user just signed in with the
+// provider again. Only an identity that's already linked counts, so this can
+// never create or link an account. Browser sessions get it in a cookie and
+// are sent back to the app.
+func (cfg *apiConfig) respondWithReauth(w http.ResponseWriter, r *http.Request, idToken oidc.IDToken, cookies bool) {
+	type response struct {
+		ReauthToken	string	`json:"reauth_token"`
+	}
+
+	identity, err := cfg.db.GetUserIdentity(r.Context(), database.GetUserIdentityParams{
+		Issuer:		idToken.Issuer,
+		Subject:	idToken.Subject,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusForbidden, "This identity isn't linked to an account", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get identity", err)
+		return
+	}
+
+	token, err := auth.MakeAudienceJWT(identity.UserID, cfg.jwtSecret, reauthAudience, reauthExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create re-authentication token", err)
+		return
+	}
+
+	if cookies {
+		http.SetCookie(w, &http.Cookie{
+			Name:		reauthCookie,
+			Value:		token,
+			Path:		"/",
+			MaxAge:		int(reauthExpiry.Seconds()),
+			HttpOnly:	true,
+			Secure:		true,
+			SameSite:	http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, "/app/", http.StatusFound)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		ReauthToken:	token,
+	})
+}
+
+// validReauthToken reports whether token is a re-authentication token from
+// respondWithReauth for userID that hasn't expired
+func (cfg *apiConfig) validReauthToken(token string, userID uuid.UUID) bool {
+	claims, err := auth.ValidateAudienceJWT(token, cfg.jwtSecret, reauthAudience)
+	return err == nil && claims.Subject == userID.String()
+}
+
+// userForIdentity finds the user an identity belongs to. The first time an
+// identity is seen it's linked to the account with the same email, or a new
+// account is created, but only if the provider vouches for the address.
+// Unverified local accounts are never linked, since whoever registered them
+// may not own the address.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, idToken oidc.IDToken) (database.User, error) {
+	identity, err := cfg.db.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Issuer:		idToken.Issuer,
+		Subject:	idToken.Subject,
+	})
+	if err == nil {
+		return cfg.db.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return database.User{}, errOIDCEmailUnverified
+	}
+
+	user, err := cfg.db.GetUser(ctx, idToken.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		user, err = cfg.createOIDCUser(ctx, idToken.Email)
+		if err != nil {
+			return database.User{}, err
+		}
+	case err != nil:
+		return database.User{}, err
+	case !user.EmailVerified:
+		return database.User{}, errOIDCAccountConflict
+	}
+
+	err = cfg.db.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		UserID:		user.ID,
+		Issuer:		idToken.Issuer,
+		Subject:	idToken.Subject,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+// createOIDCUser creates an account for someone signing in with an identity
+// provider. It gets a random password nobody knows, which the user can
+// replace through a password reset if they want one.
+func (cfg *apiConfig) createOIDCUser(ctx context.Context, email string) (database.User, error) {
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hash, err := cfg.hashPassword(ctx, password)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	created, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+		Email:			email,
+		HashedPasswords:	hash,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	_, err = cfg.db.VerifyUserEmail(ctx, database.VerifyUserEmailParams{
+		ID:	created.ID,
+		Email:	email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return cfg.db.GetUserByID(ctx, created.ID)
+}
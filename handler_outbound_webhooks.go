@@ -0,0 +1,359 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/webhooks"
+)
+
+const (
+	webhookSecretPrefix	= "whsec_"
+	// Every endpoint gets its own copy of each event, so this bounds how far
+	// one account can multiply what's sent
+	maxWebhookEndpoints	= 10
+)
+
+type WebhookEndpoint struct {
+	ID		uuid.UUID	`json:"id"`
+	URL		string		`json:"url"`
+	EventTypes	[]string	`json:"event_types"`
+	CreatedAt	time.Time	`json:"created_at"`
+	// Only returned when the endpoint is created
+	Secret		string		`json:"secret,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID		uuid.UUID			`json:"id"`
+	EventID		uuid.UUID			`json:"event_id"`
+	EventType	string				`json:"event_type"`
+	Status		string				`json:"status"`
+	Attempts	int32				`json:"attempts"`
+	CreatedAt	time.Time			`json:"created_at"`
+	NextAttemptAt	*time.Time			`json:"next_attempt_at,omitempty"`
+	LastAttemptAt	*time.Time			`json:"last_attempt_at,omitempty"`
+	DeliveredAt	*time.Time			`json:"delivered_at,omitempty"`
+	Payload		json.RawMessage			`json:"payload,omitempty"`
+	AttemptLog	[]WebhookDeliveryAttempt	`json:"attempt_log,omitempty"`
+}
+
+type WebhookDeliveryAttempt struct {
+	AttemptedAt	time.Time	`json:"attempted_at"`
+	StatusCode	int32		`json:"status_code,omitempty"`
+	Error		string		`json:"error,omitempty"`
+	DurationMs	int32		`json:"duration_ms"`
+}
+
+func webhookEndpointFromDB(endpoint database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:		endpoint.ID,
+		URL:		endpoint.Url,
+		EventTypes:	endpoint.EventTypes,
+		CreatedAt:	endpoint.CreatedAt,
+	}
+}
+
+func webhookDeliveryFromDB(delivery database.WebhookDelivery) WebhookDelivery {
+	response := WebhookDelivery{
+		ID:		delivery.ID,
+		EventID:	delivery.EventID,
+		EventType:	delivery.EventType,
+		Status:		delivery.Status,
+		Attempts:	delivery.Attempts,
+		CreatedAt:	delivery.CreatedAt,
+	}
+	if delivery.Status == "pending" {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	if delivery.LastAttemptAt.Valid {
+		response.LastAttemptAt = &delivery.LastAttemptAt.Time
+	}
+	if delivery.DeliveredAt.Valid {
+		response.DeliveredAt = &delivery.DeliveredAt.Time
+	}
+	return response
+}
+
+// webhookOwner works out whose endpoints a request manages. Under /admin
+// that's the admin endpoints, which have no user and receive every event,
+// otherwise it's the caller's own.
+func (cfg *apiConfig) webhookOwner(w http.ResponseWriter, r *http.Request) (uuid.NullUUID, bool) {
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		return uuid.NullUUID{}, cfg.requireAdmin(w, r)
+	}
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return uuid.NullUUID{}, false
+	}
+	return uuid.NullUUID{UUID: caller.userID, Valid: true}, true
+}
+
+// ownedWebhookEndpoint gets the endpoint named in the path, responding with
+// 404 if it doesn't belong to the caller
+func (cfg *apiConfig) ownedWebhookEndpoint(w http.ResponseWriter, r *http.Request) (database.WebhookEndpoint, bool) {
+	owner, ok := cfg.webhookOwner(w, r)
+	if !ok {
+		return database.WebhookEndpoint{}, false
+	}
+
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID", err)
+		return database.WebhookEndpoint{}, false
+	}
+
+	endpoint, err := cfg.db.GetWebhookEndpoint(r.Context(), database.GetWebhookEndpointParams{
+		ID:	endpointID,
+		UserID:	owner,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find endpoint", err)
+		return database.WebhookEndpoint{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get endpoint", err)
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+// validateWebhookURL only allows https, except on the dev platform so
+// endpoints can be tested locally. Hosts that are obviously private are
+// refused here for a clearer error, but the real check is made on every
+// connection by the webhook client.
+func (cfg *apiConfig) validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" || u.User != nil || u.Fragment != "" {
+		return errors.New("webhook URL must be an absolute URL without credentials or a fragment")
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && cfg.platform == "dev") {
+		return errors.New("webhook URL must use https")
+	}
+	if cfg.platform != "dev" {
+		host := u.Hostname()
+		if ip, err := netip.ParseAddr(host); err == nil && !webhooks.PublicAddress(ip) {
+			return errors.New("webhook URL must point to a public address")
+		}
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return errors.New("webhook URL must point to a public address")
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL		string		`json:"url"`
+		EventTypes	[]string	`json:"event_types"`
+	}
+
+	owner, ok := cfg.webhookOwner(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
+		return
+	}
+
+	err = cfg.validateWebhookURL(params.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(params.EventTypes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Subscribe to at least one event type", nil)
+		return
+	}
+	eventTypes := []string{}
+	for _, eventType := range params.EventTypes {
+		if !webhooks.ValidEventType(eventType) {
+			respondWithError(w, http.StatusBadRequest, "Unknown event type: " + eventType, nil)
+			return
+		}
+		if !slices.Contains(eventTypes, eventType) {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+
+	count, err := cfg.db.CountWebhookEndpoints(r.Context(), owner)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count endpoints", err)
+		return
+	}
+	if count >= maxWebhookEndpoints {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("At most %d webhook endpoints are allowed", maxWebhookEndpoints), nil)
+		return
+	}
+
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create signing secret", err)
+		return
+	}
+	secret = webhookSecretPrefix + secret
+
+	endpoint, err := cfg.db.CreateWebhookEndpoint(r.Context(), database.CreateWebhookEndpointParams{
+		UserID:		owner,
+		Url:		params.URL,
+		Secret:		secret,
+		EventTypes:	eventTypes,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create endpoint", err)
+		return
+	}
+
+	response := webhookEndpointFromDB(endpoint)
+	response.Secret = secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsList(w http.ResponseWriter, r *http.Request) {
+	owner, ok := cfg.webhookOwner(w, r)
+	if !ok {
+		return
+	}
+
+	endpoints, err := cfg.db.ListWebhookEndpoints(r.Context(), owner)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list endpoints", err)
+		return
+	}
+
+	response := []WebhookEndpoint{}
+	for _, endpoint := range endpoints {
+		response = append(response, webhookEndpointFromDB(endpoint))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsDelete(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.db.DeleteWebhookEndpoint(r.Context(), database.DeleteWebhookEndpointParams{
+		ID:	endpoint.ID,
+		UserID:	endpoint.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete endpoint", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerWebhookDeliveriesList(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := cfg.db.ListWebhookDeliveries(r.Context(), endpoint.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list deliveries", err)
+		return
+	}
+
+	response := []WebhookDelivery{}
+	for _, delivery := range deliveries {
+		response = append(response, webhookDeliveryFromDB(delivery))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerWebhookDeliveriesGet returns a delivery with its payload and a log
+// of every attempt
+func (cfg *apiConfig) handlerWebhookDeliveriesGet(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID", err)
+		return
+	}
+
+	delivery, err := cfg.db.GetWebhookDelivery(r.Context(), database.GetWebhookDeliveryParams{
+		ID:		deliveryID,
+		EndpointID:	endpoint.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find delivery", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get delivery", err)
+		return
+	}
+
+	attempts, err := cfg.db.ListWebhookDeliveryAttempts(r.Context(), delivery.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list delivery attempts", err)
+		return
+	}
+
+	response := webhookDeliveryFromDB(delivery)
+	response.Payload = json.RawMessage(delivery.Payload)
+	response.AttemptLog = []WebhookDeliveryAttempt{}
+	for _, attempt := range attempts {
+		response.AttemptLog = append(response.AttemptLog, WebhookDeliveryAttempt{
+			AttemptedAt:	attempt.AttemptedAt,
+			StatusCode:	attempt.StatusCode.Int32,
+			Error:		attempt.Error.String,
+			DurationMs:	attempt.DurationMs,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerWebhookDeliveriesRedeliver queues a delivered or dead-lettered
+// delivery again, with a fresh set of attempts
+func (cfg *apiConfig) handlerWebhookDeliveriesRedeliver(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID", err)
+		return
+	}
+
+	queued, err := cfg.db.RedeliverWebhookDelivery(r.Context(), database.RedeliverWebhookDeliveryParams{
+		ID:		deliveryID,
+		EndpointID:	endpoint.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue delivery", err)
+		return
+	}
+	if queued == 0 {
+		respondWithError(w, http.StatusConflict, "Delivery not found or already pending", nil)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/mailer"
+)
+
+const passwordResetExpiry = 30 * time.Minute
+
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email	string	`json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
+		return
+	}
+
+	if !cfg.emailRequestAllowed(w, r, params.Email) {
+		return
+	}
+
+	// Look the account up and send the email in the background, so neither
+	// the response nor its timing reveals whether the email exists
+	cfg.goBackground(func() { cfg.sendPasswordReset(params.Email) })
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) sendPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+	defer cancel()
+
+	user, err := cfg.db.GetUser(ctx, email)
+	if err != nil {
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.Error("Error creating password reset token", "error", err)
+		return
+	}
+
+	err = cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash:	auth.HashToken(token),
+		UserID:		user.ID,
+		ExpiresAt:	time.Now().Add(passwordResetExpiry),
+	})
+	if err != nil {
+		slog.Error("Error saving password reset token", "error", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/app/reset-password?token=%s", cfg.baseURL, url.QueryEscape(token))
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:		user.Email,
+		Subject:	"Reset your Chirpy password",
+		Body:		fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\nUse this link within %d minutes to choose a new one:\n%s\n\nIf this wasn't you, you can ignore this email.", int(passwordResetExpiry.Minutes()), link),
+	})
+	if err != nil {
+		slog.Error("Error sending password reset email", "error", err)
+	}
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token		string	`json:"token"`
+		Password	string	`json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
+		return
+	}
+	if !cfg.passwordAllowed(w, params.Password) {
+		return
+	}
+
+	// Marks the token used in the same statement that checks it, so it can
+	// only ever be redeemed once
+	userID, err := cfg.db.ConsumePasswordResetToken(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired reset token", err)
+		return
+	}
+
+	hash, err := cfg.hashPassword(r.Context(), params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	err = cfg.db.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:			userID,
+		HashedPasswords:	hash,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	err = cfg.db.DeleteUserPasswordResetTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't clear reset tokens", err)
+		return
+	}
+
+	err = cfg.revokeAllSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,47 +2,183 @@ package main
 
 
 import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
 	"net/http"
 	"encoding/json"
+	"errors"
+	"io"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/subscription"
 )
 
+const (
+	polkaSignatureHeader	= "Polka-Signature"
+	polkaSignatureTolerance	= 5 * time.Minute
+	maxWebhookBodyBytes	= 1 << 20
+	polkaEventSource	= "polka"
+)
+
+var errInvalidPolkaKey = errors.New("invalid API key")
+
+type polkaEvent struct {
+	ID	string	`json:"id"`
+	Event	string	`json:"event"`
+	Data	struct {
+		UserID		uuid.UUID	`json:"user_id"`
+		// Sent with upgrades and renewals, a default period is used otherwise
+		PeriodStart	time.Time	`json:"period_start"`
+		PeriodEnd	time.Time	`json:"period_end"`
+	} `json:"data"`
+}
 
 func (cfg *apiConfig) handlerPolkaWebhooks(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Event string `json:"event"`
-		Data struct {
-			UserID uuid.UUID `json:"user_id"`
-		} `json:"data"`
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Couldn't read request body", err)
+		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err := decoder.Decode(&params)
+	// Nothing in the body is trusted, or even parsed, until the request is
+	// known to come from Polka
+	err = cfg.verifyPolkaRequest(r, body)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature", err)
+		return
+	}
+
+	params := polkaEvent{}
+	err = json.Unmarshal(body, &params)
 	if err != nil { 
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode json parameters", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
 		return
 	}
 
-	key, err := auth.GetAPIKey(r.Header)
-	if key != cfg.polkaApiKey || err != nil { 
-		respondWithError(w, http.StatusUnauthorized, "Invalid API key", err)
+	// Events without an ID are deduplicated by their content instead
+	eventID := params.ID
+	if eventID == "" {
+		eventID = "sha256:" + auth.HashToken(string(body))
+	}
+
+	// A redelivery finds the event already recorded, and is only processed
+	// again if the earlier attempt failed
+	_, err = cfg.db.RecordWebhookEvent(r.Context(), database.RecordWebhookEventParams{
+		ID:		eventID,
+		Source:		polkaEventSource,
+		EventType:	params.Event,
+		Payload:	string(body),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record event", err)
 		return
 	}
 
-	switch params.Event {
-	case "user.upgraded":
-		err = cfg.db.UpdateUserAddChirpyRed(r.Context(), params.Data.UserID)
-		if err != nil {
-			respondWithError(w, http.StatusNotFound, "Couldn't upgrade user", err)
-			return
-		}
-	default:
-		respondWithError(w, http.StatusNoContent, "That event isn't supported", err)
+	_, err = cfg.processPolkaEvent(r.Context(), eventID)
+	if errors.Is(err, subscription.ErrInvalidTransition) {
+		// Redelivering won't help, but the event is kept as failed so it
+		// can be replayed if it arrived out of order
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if errors.Is(err, errSubscriptionUserNotFound) {
+		// Also kept as failed, but Polka shouldn't keep redelivering it
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if err != nil {
+		// Polka retries anything that isn't a 2xx
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process event", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// processPolkaEvent applies a recorded event and marks it processed, or
+// ignored if it's a type Chirpy doesn't handle. Both happen in one
+// transaction, and the row is locked, so an event is never applied twice even
+// when deliveries race. Failures are recorded on the event for a later replay.
+func (cfg *apiConfig) processPolkaEvent(ctx context.Context, eventID string) (database.WebhookEvent, error) {
+	event, err := cfg.applyPolkaEvent(ctx, eventID)
+	if err != nil {
+		failErr := cfg.db.FailWebhookEvent(ctx, database.FailWebhookEventParams{
+			ID:	eventID,
+			Error:	sql.NullString{String: err.Error(), Valid: true},
+		})
+		if failErr != nil {
+			return event, errors.Join(err, failErr)
+		}
+		return event, err
+	}
+	cfg.wakeOutboxRelay()
+	return event, nil
+}
+
+func (cfg *apiConfig) applyPolkaEvent(ctx context.Context, eventID string) (database.WebhookEvent, error) {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	event, err := qtx.LockWebhookEvent(ctx, eventID)
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+	if event.Status == "processed" || event.Status == "ignored" {
+		return event, nil
+	}
+
+	params := polkaEvent{}
+	err = json.Unmarshal([]byte(event.Payload), &params)
+	if err != nil {
+		return event, err
+	}
+
+	status := "processed"
+	if subscription.IsWebhookEvent(params.Event) {
+		err = applySubscriptionEvent(ctx, qtx, event.ID, params)
+		if err != nil {
+			return event, err
+		}
+	} else {
+		status = "ignored"
+	}
+
+	err = qtx.FinishWebhookEvent(ctx, database.FinishWebhookEventParams{
+		ID:	eventID,
+		Status:	status,
+	})
+	if err != nil {
+		return event, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return event, err
+	}
+	return cfg.db.GetWebhookEvent(ctx, eventID)
+}
+
+// verifyPolkaRequest checks the HMAC signature over the raw body. Until
+// POLKA_WEBHOOK_SECRETS is configured, the static API key is still accepted
+// so webhooks keep working while Polka switches over.
+func (cfg *apiConfig) verifyPolkaRequest(r *http.Request, body []byte) error {
+	if len(cfg.polkaWebhookSecrets) > 0 {
+		return auth.VerifyWebhookSignature(cfg.polkaWebhookSecrets, r.Header.Get(polkaSignatureHeader), body, polkaSignatureTolerance, time.Now())
+	}
+
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return err
+	}
+	if cfg.polkaApiKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(cfg.polkaApiKey)) != 1 {
+		return errInvalidPolkaKey
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/subscription"
+)
+
+const subscriptionExpiredEvent = "subscription.expired"
+
+// errSubscriptionUserNotFound means an event names a user Chirpy doesn't
+// have, so retrying it can never work
+var errSubscriptionUserNotFound = errors.New("user not found")
+
+// applySubscriptionEvent moves a user's subscription through the state
+// machine and keeps is_chirpy_red in step with it. It runs in the webhook
+// event's transaction.
+func applySubscriptionEvent(ctx context.Context, qtx *database.Queries, eventID string, params polkaEvent) error {
+	current, err := qtx.LockSubscription(ctx, params.Data.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = qtx.GetUserByID(ctx, params.Data.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", errSubscriptionUserNotFound, params.Data.UserID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	event := subscription.Event(params.Event)
+	status, err := subscription.Transition(subscription.Status(current.Status), event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	start, end := current.CurrentPeriodStart, current.CurrentPeriodEnd
+	switch event {
+	case subscription.EventUpgraded, subscription.EventRenewed:
+		start, end = subscription.NextPeriod(event, current.CurrentPeriodEnd, params.Data.PeriodStart, params.Data.PeriodEnd, now)
+		err = qtx.CreateSubscriptionPeriod(ctx, database.CreateSubscriptionPeriodParams{
+			UserID:		params.Data.UserID,
+			StartsAt:	start,
+			EndsAt:		end,
+			EventID:	sql.NullString{String: eventID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+	case subscription.EventDowngraded:
+		// Downgrades take effect immediately rather than at the period's end
+		if end.After(now) {
+			end = now
+		}
+	}
+
+	err = qtx.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+		UserID:			params.Data.UserID,
+		Status:			string(status),
+		CurrentPeriodStart:	start,
+		CurrentPeriodEnd:	end,
+	})
+	if err != nil {
+		return err
+	}
+	err = qtx.SetUserChirpyRed(ctx, database.SetUserChirpyRedParams{
+		ID:		params.Data.UserID,
+		IsChirpyRed:	status.Entitled(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The Polka event names double as the domain event types
+	type changed struct {
+		UserID		uuid.UUID	`json:"user_id"`
+		Status		string		`json:"status"`
+		PeriodStart	time.Time	`json:"period_start"`
+		PeriodEnd	time.Time	`json:"period_end"`
+	}
+	return recordEvent(ctx, qtx, string(event), params.Data.UserID, changed{
+		UserID:		params.Data.UserID,
+		Status:		string(status),
+		PeriodStart:	start,
+		PeriodEnd:	end,
+	})
+}
+
+// runSubscriptionExpiry downgrades users whose period ended without a
+// renewal, checking every interval until ctx is cancelled
+func (cfg *apiConfig) runSubscriptionExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cfg.expireLapsedSubscriptions(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expireLapsedSubscriptions stops between subscriptions once ctx is
+// cancelled
+func (cfg *apiConfig) expireLapsedSubscriptions(ctx context.Context) {
+	opCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	subscriptions, err := cfg.db.GetLapsedSubscriptions(opCtx)
+	if err != nil {
+		slog.Error("Error listing lapsed subscriptions", "error", err)
+		return
+	}
+	for _, s := range subscriptions {
+		if ctx.Err() != nil {
+			return
+		}
+		err := cfg.expireSubscription(opCtx, s.UserID)
+		if err != nil {
+			slog.Error("Error expiring subscription", "user_id", s.UserID, "error", err)
+		}
+	}
+}
+
+func (cfg *apiConfig) expireSubscription(ctx context.Context, userID uuid.UUID) error {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// Checked again under the lock, in case a renewal arrived since the
+	// subscription was listed
+	current, err := qtx.LockSubscription(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if current.CurrentPeriodEnd.After(time.Now()) {
+		return nil
+	}
+	status, err := subscription.Transition(subscription.Status(current.Status), subscription.EventPeriodEnded)
+	if err != nil {
+		// Already expired
+		return nil
+	}
+
+	err = qtx.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+		UserID:			userID,
+		Status:			string(status),
+		CurrentPeriodStart:	current.CurrentPeriodStart,
+		CurrentPeriodEnd:	current.CurrentPeriodEnd,
+	})
+	if err != nil {
+		return err
+	}
+	err = qtx.SetUserChirpyRed(ctx, database.SetUserChirpyRedParams{
+		ID:		userID,
+		IsChirpyRed:	status.Entitled(),
+	})
+	if err != nil {
+		return err
+	}
+	err = recordEvent(ctx, qtx, subscriptionExpiredEvent, userID, map[string]any{
+		"user_id":	userID,
+		"status":	status,
+		"period_end":	current.CurrentPeriodEnd,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
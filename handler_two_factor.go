@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/database"
+)
+
+const (
+	mfaAudience		= "chirpy-mfa"
+	// Challenges for cookie sessions get their own audience, so the session
+	// mode chosen at the first step carries over to the second
+	mfaCookieAudience	= "chirpy-mfa-cookie"
+	// Holds the challenge when the first step was a redirect from an
+	// identity provider, so it never appears in the page
+	mfaCookie		= "__Host-chirpy_mfa"
+	mfaChallengeExpiry	= 5 * time.Minute
+	recoveryCodeCount	= 10
+)
+
+func (cfg *apiConfig) handlerTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret		string	`json:"secret"`
+		OTPAuthURI	string	`json:"otpauth_uri"`
+	}
+
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+	userID := caller.userID
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+
+	// Re-enrolling would silently switch off 2FA without a code
+	if user.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create secret", err)
+		return
+	}
+
+	err = cfg.db.UpdateUserTOTPSecret(r.Context(), database.UpdateUserTOTPSecretParams{
+		ID:		userID,
+		TotpSecret:	sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:		secret,
+		OTPAuthURI:	auth.TOTPURI("Chirpy", user.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) handlerTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code	string	`json:"code"`
+	}
+	type response struct {
+		RecoveryCodes	[]string	`json:"recovery_codes"`
+	}
+
+	caller, ok := cfg.authenticate(w, r, scopeAccount)
+	if !ok {
+		return
+	}
+	userID := caller.userID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+	if user.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Two-factor enrollment hasn't been started", nil)
+		return
+	}
+
+	step, ok, err := auth.ValidateTOTP(user.TotpSecret.String, params.Code, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't validate code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+
+	// The codes and enabling TOTP go in one transaction, so a failure can't
+	// leave two-factor on with only some of the codes saved
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't replace recovery codes", err)
+		return
+	}
+
+	// Codes are random enough that a fast digest is safe, and it lets a code
+	// be found with one lookup instead of an argon2 run per stored code
+	for _, code := range codes {
+		err = qtx.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			UserID:		userID,
+			HashedCode:	auth.HashToken(code),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery code", err)
+			return
+		}
+	}
+
+	err = qtx.EnableUserTOTP(r.Context(), database.EnableUserTOTPParams{
+		ID:		userID,
+		TotpLastStep:	step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	// Recovery codes are only ever shown here
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes:	codes,
+	})
+}
+
+// respondWithMFAChallenge is the first step of a two-factor login. The
+// challenge token proves the password was correct but can't be used as an
+// access token.
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, userID uuid.UUID, cookies bool) {
+	type response struct {
+		MFARequired	bool	`json:"mfa_required"`
+		MFAToken	string	`json:"mfa_token"`
+	}
+
+	challenge, err := cfg.makeMFAChallenge(userID, cookies)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		MFARequired:	true,
+		MFAToken:	challenge,
+	})
+}
+
+// redirectWithMFAChallenge is the first step of a two-factor login for a
+// cookie session that ended in a top-level navigation. The challenge goes in
+// an HttpOnly cookie and the app is left to ask for the code.
+func (cfg *apiConfig) redirectWithMFAChallenge(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	challenge, err := cfg.makeMFAChallenge(userID, true)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:		mfaCookie,
+		Value:		challenge,
+		Path:		"/",
+		MaxAge:		int(mfaChallengeExpiry.Seconds()),
+		HttpOnly:	true,
+		Secure:		true,
+		SameSite:	http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/app/?mfa_required=true", http.StatusFound)
+}
+
+func (cfg *apiConfig) makeMFAChallenge(userID uuid.UUID, cookies bool) (string, error) {
+	audience := mfaAudience
+	if cookies {
+		audience = mfaCookieAudience
+	}
+	return auth.MakeAudienceJWT(userID, cfg.jwtSecret, audience, mfaChallengeExpiry)
+}
+
+func clearMFACookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:		mfaCookie,
+		Value:		"",
+		Path:		"/",
+		MaxAge:		-1,
+		HttpOnly:	true,
+		Secure:		true,
+		SameSite:	http.SameSiteStrictMode,
+	})
+}
+
+// loginWithMFA is the second step of a two-factor login, exchanging the
+// challenge token and a TOTP or recovery code for a session. A challenge
+// issued for a cookie session always gets one.
+func (cfg *apiConfig) loginWithMFA(w http.ResponseWriter, r *http.Request, mfaToken, code, recoveryCode string, expiresIn time.Duration, cookies bool) {
+	claims, err := auth.ValidateAudienceJWT(mfaToken, cfg.jwtSecret, mfaAudience)
+	if err != nil {
+		claims, err = auth.ValidateAudienceJWT(mfaToken, cfg.jwtSecret, mfaCookieAudience)
+		cookies = true
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token", err)
+		return
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return
+	}
+	if !user.TotpEnabled || !user.TotpSecret.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Two-factor authentication isn't enabled", nil)
+		return
+	}
+
+	// Six digit codes are guessable without a limit on attempts
+	throttleKey := "mfa:" + userID.String()
+	if !cfg.loginAllowed(w, r, throttleKey) {
+		return
+	}
+
+	var ok bool
+	if recoveryCode != "" {
+		ok, err = cfg.useRecoveryCode(r, userID, recoveryCode)
+	} else {
+		ok, err = cfg.useTOTPCode(r, user, code)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't validate code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+	cfg.loginSucceeded(r, throttleKey)
+
+	if cookies {
+		clearMFACookie(w)
+	}
+	cfg.respondWithSession(w, r, user, expiresIn, cookies)
+}
+
+func (cfg *apiConfig) useTOTPCode(r *http.Request, user database.User, code string) (bool, error) {
+	step, ok, err := auth.ValidateTOTP(user.TotpSecret.String, code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// Only advance if this step is newer than the last one used, so a code
+	// can't be replayed within its validity window
+	updated, err := cfg.db.UpdateUserTOTPLastStep(r.Context(), database.UpdateUserTOTPLastStepParams{
+		ID:		user.ID,
+		TotpLastStep:	step,
+	})
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+func (cfg *apiConfig) useRecoveryCode(r *http.Request, userID uuid.UUID, code string) (bool, error) {
+	used, err := cfg.db.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+		UserID:		userID,
+		HashedCode:	auth.HashToken(strings.ToLower(strings.TrimSpace(code))),
+	})
+	if err != nil {
+		return false, err
+	}
+	return used == 1, nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
 	"encoding/json"
 	"io"
+	"log/slog"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,6 +15,9 @@ import (
 	"github.com/pjjimiso/chirpy/internal/auth"
 )
 
+// Comfortably fits an email, a maximum length password and a TOTP code
+const maxLoginBodyBytes = 16 << 10
+
 type User struct {
 	ID		uuid.UUID	`json:"id"`
 	CreatedAt	time.Time	`json:"created_at"`
@@ -19,6 +26,8 @@ type User struct {
 	Token		string		`json:"token"`
 	RefreshToken	string		`json:"refresh_token"`
 	IsChirpyRed	bool		`json:"is_chirpy_red"`
+	EmailVerified	bool		`json:"email_verified"`
+	CSRFToken	string		`json:"csrf_token,omitempty"`
 }
 
 func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request) {
@@ -26,18 +35,18 @@ func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request)
 		Password	string		`json:"password"`
 		Email		string		`json:"email"`
 		ExpiresIn	*float64	`json:"expires_in_seconds,string,omitempty"`
+		MFAToken	string		`json:"mfa_token"`
+		Code		string		`json:"code"`
+		RecoveryCode	string		`json:"recovery_code"`
+		// "cookie" keeps the session in HttpOnly cookies for the web app
+		SessionMode	string		`json:"session_mode"`
 	}
 
-	dat, err := io.ReadAll(r.Body)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't read request parameters", err)
-		return
-	}
-
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLoginBodyBytes))
 	params := parameters{}
-	err = json.Unmarshal(dat, &params)
-	if err != nil { 
-		respondWithError(w, http.StatusInternalServerError, "Couldn't unmarshal json parameters", err)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode json parameters", err)
 		return
 	}
 
@@ -50,29 +59,124 @@ func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request)
 		expiresIn = time.Duration(*params.ExpiresIn) * time.Second
 	}
 
-	user, err := cfg.db.GetUser(r.Context(), params.Email)
+	if params.SessionMode != "" && params.SessionMode != "cookie" {
+		respondWithError(w, http.StatusBadRequest, "session_mode must be cookie or omitted", nil)
+		return
+	}
+	cookies := params.SessionMode == "cookie"
+
+	// Second step of a two-factor login. After a redirect from an identity
+	// provider the challenge is in a cookie rather than the body.
+	if params.MFAToken == "" && (params.Code != "" || params.RecoveryCode != "") {
+		if cookie, err := r.Cookie(mfaCookie); err == nil {
+			params.MFAToken = cookie.Value
+		}
+	}
+	if params.MFAToken != "" {
+		cfg.loginWithMFA(w, r, params.MFAToken, params.Code, params.RecoveryCode, expiresIn, cookies)
+		return
+	}
+
+	accountKey := accountThrottleKey(params.Email)
+	if !cfg.loginAllowed(w, r, accountKey) {
+		return
+	}
+
+	user, err := cfg.checkCredentials(r, accountKey, params.Email, params.Password)
+	if errors.Is(err, errInvalidCredentials) {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
 		return
 	}
 
-	match, err := auth.CheckPasswordHash(params.Password, user.HashedPasswords)
-	if !match || err != nil { 
-		respondWithError(w, http.StatusUnauthorized, "Invalid password", err)
+	if user.TotpEnabled {
+		cfg.respondWithMFAChallenge(w, user.ID, cookies)
 		return
 	}
 
+	cfg.respondWithSession(w, r, user, expiresIn, cookies)
+}
+
+var errInvalidCredentials = errors.New("incorrect email or password")
+
+// checkCredentials verifies an email and password. The caller must already
+// have counted the attempt with loginAttempt, and a success is recorded here.
+// Unknown emails and wrong passwords both return errInvalidCredentials and
+// take the same time, so callers can't be used to discover accounts.
+// Passwords longer than the policy allows can't be set, so they're rejected
+// without spending a hash on them.
+func (cfg *apiConfig) checkCredentials(r *http.Request, accountKey, email, password string) (database.User, error) {
+	if cfg.passwordPolicy.MaxLength > 0 && len(password) > cfg.passwordPolicy.MaxLength {
+		return database.User{}, errInvalidCredentials
+	}
 
-	accessToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, expiresIn)
+	release, err := cfg.acquireHashSlot(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token", err)
+		return database.User{}, err
+	}
+	defer release()
+
+	user, err := cfg.db.GetUser(r.Context(), email)
+	if errors.Is(err, sql.ErrNoRows) {
+		auth.CheckDummyPasswordHash(password, cfg.passwordParams)
+		return database.User{}, errInvalidCredentials
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	match, err := auth.CheckPasswordHash(password, user.HashedPasswords)
+	if !match || err != nil { 
+		return database.User{}, errInvalidCredentials
+	}
+	cfg.loginSucceeded(r, accountKey)
+
+	// Now that we know the password, upgrade hashes made with weaker
+	// parameters or imported from the old bcrypt system
+	if auth.PasswordNeedsRehash(user.HashedPasswords, cfg.passwordParams) {
+		cfg.rehashPassword(r, user.ID, password)
+	}
+	return user, nil
+}
+
+// respondWithSession starts a new session for the user and responds with the
+// access and refresh token pair, or sets them as cookies for browser sessions.
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, user database.User, expiresIn time.Duration, cookies bool) {
+	response, ok := cfg.startSession(w, r, user, expiresIn, cookies)
+	if !ok {
 		return
 	}
+	respondWithJSON(w, 200, response)
+}
+
+// redirectWithSession starts a cookie session for the user and sends the
+// browser to the app, for logins that end in a top-level navigation
+func (cfg *apiConfig) redirectWithSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	_, ok := cfg.startSession(w, r, user, time.Hour, true)
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, "/app/", http.StatusFound)
+}
+
+// startSession creates the session and, with cookies, sets its cookies,
+// responding with an error if it can't.
+func (cfg *apiConfig) startSession(w http.ResponseWriter, r *http.Request, user database.User, expiresIn time.Duration, cookies bool) (User, bool) {
+	if user.DeleteAfter.Valid {
+		err := cfg.cancelPendingDeletion(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't cancel account deletion", err)
+			return User{}, false
+		}
+	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create session token", err)
-		return
+		return User{}, false
 	}
 
 	// Expire in 60 days
@@ -87,10 +191,16 @@ func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request)
 	_, err = cfg.db.CreateRefreshToken(r.Context(), createRefreshTokenParams)
 	if err != nil { 
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)	
-		return
+		return User{}, false
 	}
 
-	respondWithJSON(w, 200, User{
+	accessToken, err := cfg.makeSessionAccessToken(r.Context(), user.ID, refreshToken, "", "", expiresIn)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token", err)
+		return User{}, false
+	}
+
+	response := User{
 		ID:		user.ID,
 		CreatedAt:	user.CreatedAt,
 		UpdatedAt:	user.UpdatedAt,
@@ -98,7 +208,22 @@ func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request)
 		Token:		accessToken,
 		RefreshToken:	refreshToken,
 		IsChirpyRed:	user.IsChirpyRed,
-	})
+		EmailVerified:	user.EmailVerified,
+	}
+
+	if cookies {
+		csrfToken, err := setSessionCookies(w, accessToken, expiresIn, refreshToken, refTokenExpiration)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create CSRF token", err)
+			return User{}, false
+		}
+		// The tokens stay in HttpOnly cookies, out of reach of scripts
+		response.Token = ""
+		response.RefreshToken = ""
+		response.CSRFToken = csrfToken
+	}
+
+	return response, true
 }
 
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
@@ -119,12 +244,15 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't unmarshal json parameters", err)
 		return
 	}
-	if params.Password == "" { 
-		respondWithError(w, http.StatusInternalServerError, "Password field can't be empty", nil)
+	if !cfg.passwordAllowed(w, params.Password) { 
+		return
+	}
+	if err := validateEmail(params.Email); err != nil { 
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
 		return
 	}
 
-	hash, err := auth.HashPassword(params.Password)
+	hash, err := cfg.hashPassword(r.Context(), params.Password)
 	if err != nil { 
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
 		return
@@ -139,6 +267,8 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 
 	}
+
+	cfg.goBackground(func() { cfg.sendVerificationEmail(user.ID, user.Email) })
 	
 	respondWithJSON(w, 201, User{
 		ID:		user.ID,
@@ -146,6 +276,7 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		UpdatedAt:	user.UpdatedAt,
 		Email:		user.Email,
 		IsChirpyRed:	user.IsChirpyRed,
+		EmailVerified:	user.EmailVerified,
 	})	
 }
 
@@ -168,38 +299,161 @@ func (cfg *apiConfig) handlerUsersUpdateCredentials(w http.ResponseWriter, r *ht
 		return
 	}
 
-	tokenString, err := auth.GetBearerToken(r.Header)
-	if err != nil { 
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get token", err)
+	caller, ok := cfg.authenticate(w, r, scopeProfileWrite)
+	if !ok {
 		return
 	}
+	userID := caller.userID
 
-	userID, err := auth.ValidateJWT(tokenString, cfg.jwtSecret)
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
 	if err != nil { 
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
 		return
 	}
 
-	hash, err := auth.HashPassword(params.Password)
+	emailChanged := params.Email != user.Email
+	if emailChanged {
+		if err := validateEmail(params.Email); err != nil { 
+			respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+			return
+		}
+		if !cfg.emailRequestAllowed(w, r, params.Email) {
+			return
+		}
+	}
+
+	if !cfg.passwordAllowed(w, params.Password) { 
+		return
+	}
+
+	hash, err := cfg.hashPassword(r.Context(), params.Password)
 	if err != nil { 
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
 		return
 	}
 
-	// TODO modify DB query to update the updated_at field
-
-	cfg.db.UpdateUserCredentials(r.Context(), database.UpdateUserCredentialsParams{
-		Email:			params.Email,
-		HashedPasswords:	hash,
+	err = cfg.db.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
 		ID:			userID,
+		HashedPasswords:	hash,
 	})
+	if err != nil { 
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update credentials", err)
+		return
+	}
+
+	// A new email only replaces the current one once it's been verified
+	if emailChanged {
+		err = cfg.db.SetUserPendingEmail(r.Context(), database.SetUserPendingEmailParams{
+			ID:		userID,
+			PendingEmail:	sql.NullString{String: params.Email, Valid: true},
+		})
+		if err != nil { 
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+			return
+		}
+		cfg.goBackground(func() { cfg.sendVerificationEmail(userID, params.Email) })
+	} else if user.PendingEmail.Valid {
+		// Going back to the current address abandons the pending change
+		err = cfg.db.SetUserPendingEmail(r.Context(), database.SetUserPendingEmailParams{
+			ID:		userID,
+			PendingEmail:	sql.NullString{},
+		})
+		if err != nil { 
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+			return
+		}
+	}
+
+	// Changing credentials signs the user out everywhere
+	err = cfg.revokeAllSessions(r.Context(), userID)
+	if err != nil { 
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	if caller.claims != nil {
+		err = cfg.denylist.Deny(r.Context(), caller.claims.ID, caller.claims.ExpiresAt.Time)
+		if err != nil { 
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access token", err)
+			return
+		}
+	}
 
 	response := struct {
-		Email string `json:"email"`
+		Email		string	`json:"email"`
+		PendingEmail	string	`json:"pending_email,omitempty"`
 	}{
-		Email: params.Email,
+		Email: user.Email,
+	}
+	if emailChanged {
+		response.PendingEmail = params.Email
 	}
 
 	respondWithJSON(w, http.StatusOK, response)
 }
 
+// acquireHashSlot waits for one of the ARGON2_MAX_CONCURRENCY slots. Each
+// argon2id run holds ARGON2_MEMORY_KIB of memory, so a burst of requests
+// queues here instead of exhausting it. Call release once the hash is done.
+func (cfg *apiConfig) acquireHashSlot(ctx context.Context) (release func(), err error) {
+	select {
+	case cfg.hashSlots <- struct{}{}:
+		return func() { <-cfg.hashSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// hashPassword is auth.HashPassword run in a hash slot
+func (cfg *apiConfig) hashPassword(ctx context.Context, password string) (string, error) {
+	release, err := cfg.acquireHashSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return auth.HashPassword(password, cfg.passwordParams)
+}
+
+// rehashPassword replaces a user's stored hash. Failure isn't fatal to the
+// login, the upgrade is just retried next time. The caller already holds a
+// hash slot.
+func (cfg *apiConfig) rehashPassword(r *http.Request, userID uuid.UUID, password string) {
+	hash, err := auth.HashPassword(password, cfg.passwordParams)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rehashing password", "user_id", userID, "error", err)
+		return
+	}
+
+	err = cfg.db.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:			userID,
+		HashedPasswords:	hash,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error saving rehashed password", "user_id", userID, "error", err)
+	}
+}
+
+// passwordAllowed checks a new password against the password policy,
+// responding with every rule it breaks if it isn't acceptable
+func (cfg *apiConfig) passwordAllowed(w http.ResponseWriter, password string) bool {
+	type response struct {
+		Error		string			`json:"error"`
+		Violations	[]auth.PasswordViolation	`json:"violations"`
+		RequestID	string			`json:"request_id,omitempty"`
+	}
+
+	violations, err := cfg.passwordPolicy.Validate(password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check password", err)
+		return false
+	}
+	if violations != nil {
+		respondWithJSON(w, http.StatusBadRequest, response{
+			Error:		"Password doesn't meet requirements",
+			Violations:	violations,
+			RequestID:	w.Header().Get(requestIDHeader),
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/subscription"
+)
+
+const (
+	defaultWebhookEventsLimit	= 50
+	maxWebhookEventsLimit		= 500
+)
+
+type WebhookEvent struct {
+	ID		string		`json:"id"`
+	Source		string		`json:"source"`
+	EventType	string		`json:"event_type"`
+	Payload		json.RawMessage	`json:"payload"`
+	ReceivedAt	time.Time	`json:"received_at"`
+	ProcessedAt	*time.Time	`json:"processed_at"`
+	Status		string		`json:"status"`
+	Error		string		`json:"error,omitempty"`
+	Attempts	int32		`json:"attempts"`
+}
+
+func webhookEventFromDB(event database.WebhookEvent) WebhookEvent {
+	response := WebhookEvent{
+		ID:		event.ID,
+		Source:		event.Source,
+		EventType:	event.EventType,
+		Payload:	json.RawMessage(event.Payload),
+		ReceivedAt:	event.ReceivedAt,
+		Status:		event.Status,
+		Error:		event.Error.String,
+		Attempts:	event.Attempts,
+	}
+	if event.ProcessedAt.Valid {
+		response.ProcessedAt = &event.ProcessedAt.Time
+	}
+	return response
+}
+
+func (cfg *apiConfig) handlerWebhookEventsList(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	limit := defaultWebhookEventsLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxWebhookEventsLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 500", err)
+			return
+		}
+	}
+
+	events, err := cfg.db.ListWebhookEvents(r.Context(), database.ListWebhookEventsParams{
+		Status:		r.URL.Query().Get("status"),
+		MaxResults:	int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list events", err)
+		return
+	}
+
+	response := []WebhookEvent{}
+	for _, event := range events {
+		response = append(response, webhookEventFromDB(event))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerWebhookEventsReplay processes a failed event again from its stored
+// payload, for when the cause of the failure has been fixed
+func (cfg *apiConfig) handlerWebhookEventsReplay(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	event, err := cfg.db.GetWebhookEvent(r.Context(), r.PathValue("eventID"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find event", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get event", err)
+		return
+	}
+	if event.Status != "failed" {
+		respondWithError(w, http.StatusConflict, "Only failed events can be replayed", nil)
+		return
+	}
+
+	event, err = cfg.processPolkaEvent(r.Context(), event.ID)
+	if errors.Is(err, errSubscriptionUserNotFound) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find the event's user", err)
+		return
+	}
+	if errors.Is(err, subscription.ErrInvalidTransition) {
+		respondWithError(w, http.StatusConflict, "Event doesn't fit the subscription's current state", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process event", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhookEventFromDB(event))
+}
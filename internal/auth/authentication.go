@@ -1,20 +1,65 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 	"net/http"
 	"regexp"
+	"sync"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
 
 	"github.com/google/uuid"
 	"github.com/alexedwards/argon2id"
 	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+const (
+	// OWASP's minimum for argon2id
+	MinPasswordMemoryKiB	= 19 * 1024
+	MinPasswordIterations	= 1
+)
+
+// DefaultPasswordParams are the argon2id parameters used for new password
+// hashes unless configured otherwise
+var DefaultPasswordParams = argon2id.Params{
+	Memory:		argon2id.DefaultParams.Memory,
+	Iterations:	argon2id.DefaultParams.Iterations,
+	Parallelism:	argon2id.DefaultParams.Parallelism,
+	SaltLength:	argon2id.DefaultParams.SaltLength,
+	KeyLength:	argon2id.DefaultParams.KeyLength,
+}
+
+// NewPasswordParams returns argon2id parameters for new hashes, refusing any
+// too weak to use or that argon2 would panic on. Zero parallelism uses one
+// thread per CPU.
+func NewPasswordParams(memoryKiB, iterations uint32, parallelism uint8) (*argon2id.Params, error) {
+	params := DefaultPasswordParams
+	params.Memory = memoryKiB
+	params.Iterations = iterations
+	if parallelism != 0 {
+		params.Parallelism = parallelism
+	}
+	if params.Memory < MinPasswordMemoryKiB {
+		return nil, fmt.Errorf("argon2 memory must be at least %d KiB", MinPasswordMemoryKiB)
+	}
+	if params.Iterations < MinPasswordIterations {
+		return nil, fmt.Errorf("argon2 iterations must be at least %d", MinPasswordIterations)
+	}
+	return &params, nil
+}
+
+// HashPassword hashes a password with argon2id. Existing hashes with weaker
+// parameters are upgraded the next time the user logs in, see
+// PasswordNeedsRehash.
+func HashPassword(password string, params *argon2id.Params) (string, error) {
+	hash, err := argon2id.CreateHash(password, params)
 	if err != nil { 
 		return "", err
 	}
@@ -22,6 +67,18 @@ func HashPassword(password string) (string, error) {
 }
 
 func CheckPasswordHash(password, hash string) (bool, error) {
+	// Accounts imported from the old system still have bcrypt hashes
+	if isBcryptHash(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil { 
+			return false, fmt.Errorf("error comparing password hash: %s", err)
+		}
+		return true, nil
+	}
+
 	match, err := argon2id.ComparePasswordAndHash(password, hash)
 	if err != nil { 
 		return match, fmt.Errorf("error comparing password hash: %s", err)
@@ -29,13 +86,71 @@ func CheckPasswordHash(password, hash string) (bool, error) {
 	return match, nil
 }
 
+// PasswordNeedsRehash reports whether hash is bcrypt or uses weaker argon2id
+// parameters than params, in which case it should be replaced with a fresh
+// hash once the password is known.
+func PasswordNeedsRehash(hash string, params *argon2id.Params) bool {
+	if isBcryptHash(hash) {
+		return true
+	}
+
+	current, _, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return false
+	}
+	// Parallelism only follows the CPU count, it doesn't change the cost
+	return current.Memory < params.Memory ||
+		current.Iterations < params.Iterations ||
+		current.SaltLength < params.SaltLength ||
+		current.KeyLength < params.KeyLength
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// Dummy hashes are made once for each set of parameters
+var dummyHashes sync.Map
+
+// CheckDummyPasswordHash does the same work as CheckPasswordHash against a
+// throwaway hash made with params, so a login for an unknown email takes as
+// long as one with a wrong password.
+func CheckDummyPasswordHash(password string, params *argon2id.Params) {
+	hash, ok := dummyHashes.Load(*params)
+	if !ok {
+		created, _ := HashPassword("chirpy-dummy-password", params)
+		hash, _ = dummyHashes.LoadOrStore(*params, created)
+	}
+	CheckPasswordHash(password, hash.(string))
+}
+
+// Claims are the claims carried by Chirpy access tokens. Tokens issued to
+// third-party OAuth clients name the client and the scopes the user granted
+// it; first-party tokens leave both empty.
+type Claims struct {
+	jwt.RegisteredClaims
+	ClientID	string	`json:"client_id,omitempty"`
+	Scope		string	`json:"scope,omitempty"`
+}
+
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return MakeScopedJWT(userID, tokenSecret, "", "", expiresIn)
+}
+
+// MakeScopedJWT issues an access token for an OAuth client limited to scope,
+// a space separated list as in RFC 6749.
+func MakeScopedJWT(userID uuid.UUID, tokenSecret, clientID, scope string, expiresIn time.Duration) (string, error) {
 	now := time.Now()
-	claims := &jwt.RegisteredClaims{
-		IssuedAt:	jwt.NewNumericDate(now),
-		ExpiresAt:	jwt.NewNumericDate(now.Add(expiresIn)),
-		Issuer:		"chirpy",
-		Subject:	userID.String(),
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:	jwt.NewNumericDate(now),
+			ExpiresAt:	jwt.NewNumericDate(now.Add(expiresIn)),
+			Issuer:		"chirpy",
+			Subject:	userID.String(),
+			ID:		uuid.NewString(),
+		},
+		ClientID:	clientID,
+		Scope:		scope,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(tokenSecret))
@@ -47,22 +162,66 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
+	claims, err := ParseJWT(tokenString, tokenSecret)
+	if err != nil { 
+		return uuid.Nil, err
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil { 
+		return uuid.Nil, fmt.Errorf("error parsing id into uuid")
+	}
+	return userID, nil
+}
+
+// ParseJWT validates the token and returns its claims, so callers that need
+// more than the subject (e.g. the jti for revocation checks) can read them.
+func ParseJWT(tokenString, tokenSecret string) (*Claims, error) {
+	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
 		return []byte(tokenSecret), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil { 
-		return uuid.Nil, fmt.Errorf("validating jwt: %s", err)
+		return nil, fmt.Errorf("validating jwt: %s", err)
 	}
 	if !token.Valid {
-		return uuid.Nil, fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
+	}
+	// Tokens minted for a specific purpose are never valid as access tokens
+	if len(claims.Audience) != 0 {
+		return nil, fmt.Errorf("invalid token audience")
 	}
+	return claims, nil
+}
 
-	userID, err := uuid.Parse(claims.Subject)
+// MakeAudienceJWT issues a short-lived token that is only accepted by
+// ValidateAudienceJWT for the same audience, e.g. an MFA login challenge.
+func MakeAudienceJWT(userID uuid.UUID, tokenSecret, audience string, expiresIn time.Duration) (string, error) {
+	now := time.Now()
+	claims := &jwt.RegisteredClaims{
+		IssuedAt:	jwt.NewNumericDate(now),
+		ExpiresAt:	jwt.NewNumericDate(now.Add(expiresIn)),
+		Issuer:		"chirpy",
+		Subject:	userID.String(),
+		Audience:	jwt.ClaimStrings{audience},
+		ID:		uuid.NewString(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+func ValidateAudienceJWT(tokenString, tokenSecret, audience string) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		return []byte(tokenSecret), nil
+	}, jwt.WithAudience(audience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil { 
-		return uuid.Nil, fmt.Errorf("error parsing id into uuid")
+		return nil, fmt.Errorf("validating jwt: %s", err)
 	}
-	return userID, nil
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -80,6 +239,18 @@ func GetBearerToken(headers http.Header) (string, error) {
 	return token, nil
 }
 
+// PKCEChallenge derives the S256 code challenge for a PKCE code verifier (RFC 7636)
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPKCE checks an OAuth code verifier against the S256 code challenge
+// sent with the authorization request
+func VerifyPKCE(verifier, challenge string) bool {
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
+
 func MakeRefreshToken() (string, error) {
 	key := make([]byte, 32)
 	rand.Read(key)
@@ -87,6 +258,14 @@ func MakeRefreshToken() (string, error) {
 	return encodedKey, nil
 }
 
+// HashToken returns a SHA-256 digest of a random token for storage. Tokens
+// are long and random, so a slow password hash isn't needed and the digest
+// can be looked up directly.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	param := headers.Get("Authorization")
 	if param == "" {
@@ -5,14 +5,16 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/alexedwards/argon2id"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHashValidatePassword(t *testing.T) {
 	password1 := "p@ssword123"
 	password2 := "someotherpassword"
-	hash1, _ := HashPassword(password1)
-	hash2, _ := HashPassword(password2)
+	hash1, _ := HashPassword(password1, &DefaultPasswordParams)
+	hash2, _ := HashPassword(password2, &DefaultPasswordParams)
 
 	tests := []struct {
 		name		string
@@ -185,3 +187,182 @@ func TestGetBearerToken(t *testing.T) {
 	}
 }
 
+
+func TestCheckLegacyBcryptHash(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("p@ssword123"), bcrypt.MinCost)
+
+	tests := []struct {
+		name		string
+		password	string
+		wantMatch	bool
+	}{
+		{
+			name:		"Correct password",
+			password:	"p@ssword123",
+			wantMatch:	true,
+		},
+		{
+			name:		"Incorrect password",
+			password:	"wrongpassword",
+			wantMatch:	false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := CheckPasswordHash(tt.password, string(hash))
+			if err != nil {
+				t.Errorf("CheckPasswordHash() error = %v", err)
+				return
+			}
+			if match != tt.wantMatch {
+				t.Errorf("CheckPasswordHash() match = %v, wantMatch %v", match, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	current, _ := HashPassword("p@ssword123", &DefaultPasswordParams)
+	weakParams := DefaultPasswordParams
+	weakParams.Memory = DefaultPasswordParams.Memory / 2
+	weak, _ := argon2id.CreateHash("p@ssword123", &weakParams)
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("p@ssword123"), bcrypt.MinCost)
+
+	tests := []struct {
+		name		string
+		hash		string
+		wantRehash	bool
+	}{
+		{
+			name:		"Current parameters",
+			hash:		current,
+			wantRehash:	false,
+		},
+		{
+			name:		"Weaker argon2id parameters",
+			hash:		weak,
+			wantRehash:	true,
+		},
+		{
+			name:		"Legacy bcrypt",
+			hash:		string(legacy),
+			wantRehash:	true,
+		},
+		{
+			name:		"Invalid hash",
+			hash:		"invalidhash",
+			wantRehash:	false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PasswordNeedsRehash(tt.hash, &DefaultPasswordParams); got != tt.wantRehash {
+				t.Errorf("PasswordNeedsRehash() = %v, wantRehash %v", got, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestNewPasswordParams(t *testing.T) {
+	tests := []struct {
+		name		string
+		memoryKiB	uint32
+		iterations	uint32
+		parallelism	uint8
+		wantErr		bool
+	}{
+		{
+			name:		"Defaults",
+			memoryKiB:	64 * 1024,
+			iterations:	1,
+		},
+		{
+			name:		"Explicit parallelism",
+			memoryKiB:	64 * 1024,
+			iterations:	3,
+			parallelism:	4,
+		},
+		{
+			name:		"Zero iterations",
+			memoryKiB:	64 * 1024,
+			iterations:	0,
+			wantErr:	true,
+		},
+		{
+			name:		"Too little memory",
+			memoryKiB:	1024,
+			iterations:	1,
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := NewPasswordParams(tt.memoryKiB, tt.iterations, tt.parallelism)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewPasswordParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if params.Parallelism == 0 || (tt.parallelism != 0 && params.Parallelism != tt.parallelism) {
+				t.Errorf("NewPasswordParams() parallelism = %d, want %d or the CPU count", params.Parallelism, tt.parallelism)
+			}
+			_, err = HashPassword("p@ssword123", params)
+			if err != nil {
+				t.Errorf("HashPassword() error = %v", err)
+			}
+			if DefaultPasswordParams.Memory != argon2id.DefaultParams.Memory {
+				t.Errorf("NewPasswordParams() changed the defaults")
+			}
+		})
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		name		string
+		verifier	string
+		challenge	string
+		want		bool
+	}{
+		{
+			name:		"Matching verifier",
+			verifier:	verifier,
+			challenge:	challenge,
+			want:		true,
+		},
+		{
+			name:		"Wrong verifier",
+			verifier:	verifier + "x",
+			challenge:	challenge,
+			want:		false,
+		},
+		{
+			name:		"Plain challenge",
+			verifier:	verifier,
+			challenge:	verifier,
+			want:		false,
+		},
+		{
+			name:		"Empty verifier",
+			verifier:	"",
+			challenge:	challenge,
+			want:		false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPKCE(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("VerifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
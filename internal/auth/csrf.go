@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+// ErrCSRFTokenMismatch is returned for a cookie-authenticated request that
+// fails the double-submit check
+var ErrCSRFTokenMismatch = errors.New("missing or invalid CSRF token")
+
+// RequestToken returns the token from the Authorization header, falling back
+// to the named session cookie. Browsers attach cookies to cross-site requests
+// on their own, so a state-changing request authenticated by cookie must also
+// repeat the CSRF cookie in csrfHeader, which other sites can't read.
+func RequestToken(r *http.Request, cookieName, csrfCookie, csrfHeader string) (token string, fromCookie bool, err error) {
+	token, err = GetBearerToken(r.Header)
+	if err == nil {
+		return token, false, nil
+	}
+
+	cookie, cookieErr := r.Cookie(cookieName)
+	if cookieErr != nil || cookie.Value == "" {
+		return "", false, err
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if !ValidCSRFToken(r, csrfCookie, csrfHeader) {
+			return "", true, ErrCSRFTokenMismatch
+		}
+	}
+	return cookie.Value, true, nil
+}
+
+// ValidCSRFToken reports whether the request repeats its CSRF cookie in
+// csrfHeader
+func ValidCSRFToken(r *http.Request, csrfCookie, csrfHeader string) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(csrfHeader)
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
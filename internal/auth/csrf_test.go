@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestToken(t *testing.T) {
+	const (
+		sessionCookie	= "session"
+		csrfCookie	= "csrf"
+		csrfHeader	= "X-CSRF-Token"
+	)
+
+	tests := []struct {
+		name		string
+		method		string
+		bearer		string
+		session		string
+		csrfCookie	string
+		csrfHeader	string
+		wantToken	string
+		wantCookie	bool
+		wantErr		bool
+		wantMismatch	bool
+	}{
+		{
+			name:		"Bearer header bypasses CSRF",
+			method:		http.MethodPost,
+			bearer:		"bearer-token",
+			session:	"cookie-token",
+			wantToken:	"bearer-token",
+		},
+		{
+			name:		"Cookie with matching header",
+			method:		http.MethodPost,
+			session:	"cookie-token",
+			csrfCookie:	"csrf-token",
+			csrfHeader:	"csrf-token",
+			wantToken:	"cookie-token",
+			wantCookie:	true,
+		},
+		{
+			name:		"Cookie without header",
+			method:		http.MethodPost,
+			session:	"cookie-token",
+			csrfCookie:	"csrf-token",
+			wantCookie:	true,
+			wantErr:	true,
+			wantMismatch:	true,
+		},
+		{
+			name:		"Header doesn't match cookie",
+			method:		http.MethodDelete,
+			session:	"cookie-token",
+			csrfCookie:	"csrf-token",
+			csrfHeader:	"other-token",
+			wantCookie:	true,
+			wantErr:	true,
+			wantMismatch:	true,
+		},
+		{
+			name:		"Empty CSRF cookie and header",
+			method:		http.MethodPut,
+			session:	"cookie-token",
+			wantCookie:	true,
+			wantErr:	true,
+			wantMismatch:	true,
+		},
+		{
+			name:		"GET is exempt",
+			method:		http.MethodGet,
+			session:	"cookie-token",
+			wantToken:	"cookie-token",
+			wantCookie:	true,
+		},
+		{
+			name:		"HEAD is exempt",
+			method:		http.MethodHead,
+			session:	"cookie-token",
+			wantToken:	"cookie-token",
+			wantCookie:	true,
+		},
+		{
+			name:		"OPTIONS is exempt",
+			method:		http.MethodOptions,
+			session:	"cookie-token",
+			wantToken:	"cookie-token",
+			wantCookie:	true,
+		},
+		{
+			name:		"No token",
+			method:		http.MethodGet,
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/users", nil)
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer " + tt.bearer)
+			}
+			if tt.session != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.session})
+			}
+			if tt.csrfCookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				r.Header.Set(csrfHeader, tt.csrfHeader)
+			}
+
+			token, fromCookie, err := RequestToken(r, sessionCookie, csrfCookie, csrfHeader)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RequestToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrCSRFTokenMismatch) != tt.wantMismatch {
+				t.Errorf("RequestToken() error = %v, wantMismatch %v", err, tt.wantMismatch)
+			}
+			if token != tt.wantToken {
+				t.Errorf("RequestToken() token = %q, want %q", token, tt.wantToken)
+			}
+			if fromCookie != tt.wantCookie {
+				t.Errorf("RequestToken() fromCookie = %v, want %v", fromCookie, tt.wantCookie)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DenylistStore persists revoked access token IDs so revocations survive
+// restarts and are shared between server instances.
+type DenylistStore interface {
+	DenyToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenDenied(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredTokens(ctx context.Context) (int64, error)
+}
+
+// Denylist tracks revoked access tokens by their jti. Denied IDs are cached
+// in memory until the token itself would have expired, after which there is
+// no point remembering them, and Prune forgets them.
+type Denylist struct {
+	store	DenylistStore
+	mu	sync.Mutex
+	cache	map[string]time.Time
+}
+
+func NewDenylist(store DenylistStore) *Denylist {
+	return &Denylist{
+		store:	store,
+		cache:	make(map[string]time.Time),
+	}
+}
+
+func (d *Denylist) Deny(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return fmt.Errorf("token has no jti")
+	}
+	if time.Now().After(expiresAt) {
+		// Already expired, nothing to revoke
+		return nil
+	}
+
+	err := d.store.DenyToken(ctx, jti, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error denying token: %s", err)
+	}
+
+	d.mu.Lock()
+	d.cache[jti] = expiresAt
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Denylist) IsDenied(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+
+	d.mu.Lock()
+	exp, cached := d.cache[jti]
+	d.mu.Unlock()
+	if cached && now.Before(exp) {
+		return true, nil
+	}
+
+	denied, err := d.store.IsTokenDenied(ctx, jti)
+	if err != nil {
+		return false, fmt.Errorf("error checking denylist: %s", err)
+	}
+	if denied {
+		d.mu.Lock()
+		d.cache[jti] = expiresAt
+		d.mu.Unlock()
+	}
+	return denied, nil
+}
+
+// Prune drops expired entries from the cache and the store. It's run on a
+// timer rather than on every lookup, so checking a token stays cheap.
+func (d *Denylist) Prune(ctx context.Context) (int64, error) {
+	now := time.Now()
+	d.mu.Lock()
+	for id, exp := range d.cache {
+		if now.After(exp) {
+			delete(d.cache, id)
+		}
+	}
+	d.mu.Unlock()
+
+	deleted, err := d.store.DeleteExpiredTokens(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error pruning denylist: %s", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeDenylistStore struct {
+	denied	map[string]time.Time
+	lookups	int
+}
+
+func (s *fakeDenylistStore) DenyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.denied[jti] = expiresAt
+	return nil
+}
+
+func (s *fakeDenylistStore) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	s.lookups++
+	_, ok := s.denied[jti]
+	return ok, nil
+}
+
+func (s *fakeDenylistStore) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	var deleted int64
+	for jti, expiresAt := range s.denied {
+		if time.Now().After(expiresAt) {
+			delete(s.denied, jti)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func TestDenylist(t *testing.T) {
+	store := &fakeDenylistStore{denied: map[string]time.Time{}}
+	denylist := NewDenylist(store)
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	if err := denylist.Deny(ctx, "revoked", expiresAt); err != nil {
+		t.Fatalf("Deny() error = %v", err)
+	}
+	if err := denylist.Deny(ctx, "", expiresAt); err == nil {
+		t.Errorf("Deny() with empty jti should fail")
+	}
+	if err := denylist.Deny(ctx, "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Errorf("Deny() expired token error = %v", err)
+	}
+	if _, ok := store.denied["expired"]; ok {
+		t.Errorf("Deny() stored an already expired token")
+	}
+
+	tests := []struct {
+		name		string
+		jti		string
+		wantDenied	bool
+		wantLookups	int
+	}{
+		{
+			name:		"Revoked token served from cache",
+			jti:		"revoked",
+			wantDenied:	true,
+			wantLookups:	0,
+		},
+		{
+			name:		"Unknown token checks the store",
+			jti:		"active",
+			wantDenied:	false,
+			wantLookups:	1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.lookups = 0
+			denied, err := denylist.IsDenied(ctx, tt.jti, expiresAt)
+			if err != nil {
+				t.Errorf("IsDenied() error = %v", err)
+				return
+			}
+			if denied != tt.wantDenied {
+				t.Errorf("IsDenied() = %v, wantDenied %v", denied, tt.wantDenied)
+			}
+			if store.lookups != tt.wantLookups {
+				t.Errorf("IsDenied() store lookups = %v, want %v", store.lookups, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestDenylistPrune(t *testing.T) {
+	store := &fakeDenylistStore{denied: map[string]time.Time{}}
+	denylist := NewDenylist(store)
+	ctx := context.Background()
+
+	err := denylist.Deny(ctx, "short", time.Now().Add(50 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("Deny() error = %v", err)
+	}
+	err = denylist.Deny(ctx, "long", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Deny() error = %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	deleted, err := denylist.Prune(ctx)
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Prune() deleted = %d, want 1", deleted)
+	}
+	if _, ok := denylist.cache["short"]; ok {
+		t.Errorf("Prune() kept the expired token in the cache")
+	}
+	if _, ok := denylist.cache["long"]; !ok {
+		t.Errorf("Prune() dropped a token that hasn't expired")
+	}
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BreachedPasswordChecker reports whether a password is known to have leaked
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+type PasswordPolicy struct {
+	MinLength	int
+	// Caps the bytes argon2id has to hash per login attempt
+	MaxLength	int
+	MinEntropyBits	float64
+	Breached	BreachedPasswordChecker
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:	8,
+	MaxLength:	128,
+	MinEntropyBits:	40,
+}
+
+type PasswordViolation struct {
+	Rule	string	`json:"rule"`
+	Message	string	`json:"message"`
+}
+
+// Validate returns every rule the password breaks, or nil if it's acceptable
+func (p PasswordPolicy) Validate(password string) ([]PasswordViolation, error) {
+	violations := []PasswordViolation{}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Rule:		"min_length",
+			Message:	fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Rule:		"max_length",
+			Message:	fmt.Sprintf("Password must be at most %d bytes", p.MaxLength),
+		})
+	}
+	if EstimateEntropy(password) < p.MinEntropyBits {
+		violations = append(violations, PasswordViolation{
+			Rule:		"entropy",
+			Message:	"Password is too easy to guess, try a longer or more varied one",
+		})
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, PasswordViolation{
+				Rule:		"breached",
+				Message:	"Password has appeared in a data breach, choose a different one",
+			})
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil, nil
+	}
+	return violations, nil
+}
+
+// EstimateEntropy gives a rough strength in bits from the size of the
+// character pool the password draws on. Repeating the previous character
+// adds nothing, so "aaaaaaaa" scores as a single character.
+func EstimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	length := 0
+	var prev rune
+	for i, c := range password {
+		switch {
+		case c < utf8.RuneSelf && unicode.IsLower(c):
+			lower = true
+		case c < utf8.RuneSelf && unicode.IsUpper(c):
+			upper = true
+		case c < utf8.RuneSelf && unicode.IsDigit(c):
+			digit = true
+		case c < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+		if i == 0 || c != prev {
+			length++
+		}
+		prev = c
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// BreachedPasswordList checks passwords against a local copy of a breached
+// password list in the Have I Been Pwned k-anonymity layout: a directory of
+// files named after the first 5 hex characters of a SHA-1 hash, like
+// 21BD1.txt (as PwnedPasswordsDownloader writes them), each holding the other
+// 35 characters of every hash with that prefix, optionally followed by
+// ":count". A check only reads the one small bucket its prefix names.
+type BreachedPasswordList struct {
+	dir	string
+}
+
+func OpenBreachedPasswordList(dir string) (*BreachedPasswordList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening breached password list: %s", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password list %s must be a directory of hash prefix files", dir)
+	}
+	return &BreachedPasswordList{dir: dir}, nil
+}
+
+func (l *BreachedPasswordList) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(l.dir, prefix + ".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		// The full list has every prefix, but a trimmed copy might not
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading breached password list: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
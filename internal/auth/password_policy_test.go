@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeBreachedList lays the hashes out in prefix files, with the counts
+// and CRLF line endings the real downloads have
+func writeBreachedList(t *testing.T, passwords []string) string {
+	t.Helper()
+	buckets := map[string][]string{}
+	for i, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		buckets[hash[:5]] = append(buckets[hash[:5]], hash[5:] + ":" + strings.Repeat("1", i + 1))
+	}
+
+	dir := t.TempDir()
+	for prefix, lines := range buckets {
+		slices.Sort(lines)
+		err := os.WriteFile(filepath.Join(dir, prefix + ".txt"), []byte(strings.Join(lines, "\r\n") + "\r\n"), 0600)
+		if err != nil {
+			t.Fatalf("writing breached list: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestBreachedPasswordList(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "letmein", "Tr0ub4dor&3", "hunter2", "iloveyou"}
+	list, err := OpenBreachedPasswordList(writeBreachedList(t, breached))
+	if err != nil {
+		t.Fatalf("OpenBreachedPasswordList() error = %v", err)
+	}
+
+	for _, password := range breached {
+		got, err := list.IsBreached(password)
+		if err != nil || !got {
+			t.Errorf("IsBreached(%q) = %v, %v, want true", password, got, err)
+		}
+	}
+	for _, password := range []string{"correct horse battery staple", "Password", ""} {
+		got, err := list.IsBreached(password)
+		if err != nil || got {
+			t.Errorf("IsBreached(%q) = %v, %v, want false", password, got, err)
+		}
+	}
+}
+
+func TestOpenBreachedPasswordListRejectsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	err := os.WriteFile(path, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:1\r\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenBreachedPasswordList(path)
+	if err == nil {
+		t.Error("OpenBreachedPasswordList() of a single file succeeded, want an error")
+	}
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	list, err := OpenBreachedPasswordList(writeBreachedList(t, []string{"Summer2024!x"}))
+	if err != nil {
+		t.Fatalf("OpenBreachedPasswordList() error = %v", err)
+	}
+
+	policy := DefaultPasswordPolicy
+	policy.Breached = list
+
+	tests := []struct {
+		name		string
+		password	string
+		wantRules	[]string
+	}{
+		{
+			name:		"Strong password",
+			password:	"correct horse battery staple",
+			wantRules:	nil,
+		},
+		{
+			name:		"Empty password",
+			password:	"",
+			wantRules:	[]string{"min_length", "entropy"},
+		},
+		{
+			name:		"Repeated characters",
+			password:	"aaaaaaaaaaaa",
+			wantRules:	[]string{"entropy"},
+		},
+		{
+			name:		"Too long",
+			password:	strings.Repeat("abcdefgh", 17),
+			wantRules:	[]string{"max_length"},
+		},
+		{
+			name:		"Breached",
+			password:	"Summer2024!x",
+			wantRules:	[]string{"breached"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := policy.Validate(tt.password)
+			if err != nil {
+				t.Errorf("Validate() error = %v", err)
+				return
+			}
+			var rules []string
+			for _, v := range violations {
+				rules = append(rules, v.Rule)
+			}
+			if !slices.Equal(rules, tt.wantRules) {
+				t.Errorf("Validate() rules = %v, wantRules %v", rules, tt.wantRules)
+			}
+		})
+	}
+}
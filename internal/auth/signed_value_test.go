@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifySignedValue(t *testing.T) {
+	valid := SignValue("itsasecret", "verify-email", "some value", time.Now().Add(time.Hour))
+	expired := SignValue("itsasecret", "verify-email", "some value", time.Now().Add(-time.Second))
+
+	tests := []struct {
+		name		string
+		token		string
+		secret		string
+		purpose		string
+		wantValue	string
+		wantErr		bool
+	}{
+		{
+			name:		"Valid token",
+			token:		valid,
+			secret:		"itsasecret",
+			purpose:	"verify-email",
+			wantValue:	"some value",
+			wantErr:	false,
+		},
+		{
+			name:		"Wrong secret",
+			token:		valid,
+			secret:		"wrongsecret",
+			purpose:	"verify-email",
+			wantErr:	true,
+		},
+		{
+			name:		"Wrong purpose",
+			token:		valid,
+			secret:		"itsasecret",
+			purpose:	"magic-link",
+			wantErr:	true,
+		},
+		{
+			name:		"Expired token",
+			token:		expired,
+			secret:		"itsasecret",
+			purpose:	"verify-email",
+			wantErr:	true,
+		},
+		{
+			name:		"Malformed token",
+			token:		"notatoken",
+			secret:		"itsasecret",
+			purpose:	"verify-email",
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := VerifySignedValue(tt.secret, tt.purpose, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifySignedValue() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if value != tt.wantValue {
+				t.Errorf("VerifySignedValue() value = %v, wantValue %v", value, tt.wantValue)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// ThrottlePolicy controls how quickly repeated login failures are slowed down
+type ThrottlePolicy struct {
+	// Failures allowed before any delay is imposed
+	FreeAttempts	int
+	// Delay after the first failure past FreeAttempts, doubling each time
+	BaseDelay	time.Duration
+	MaxDelay	time.Duration
+	// Failures after which the key is locked out for LockoutDuration
+	LockoutAfter	int
+	LockoutDuration	time.Duration
+	// How long after the last failure the counter is forgotten
+	ResetAfter	time.Duration
+}
+
+var DefaultAccountThrottlePolicy = ThrottlePolicy{
+	FreeAttempts:		3,
+	BaseDelay:		time.Second,
+	MaxDelay:		5 * time.Minute,
+	LockoutAfter:		10,
+	LockoutDuration:	15 * time.Minute,
+	ResetAfter:		24 * time.Hour,
+}
+
+// Many users can share an address behind NAT, so IPs get more headroom
+var DefaultIPThrottlePolicy = ThrottlePolicy{
+	FreeAttempts:		20,
+	BaseDelay:		time.Second,
+	MaxDelay:		5 * time.Minute,
+	LockoutAfter:		100,
+	LockoutDuration:	time.Hour,
+	ResetAfter:		24 * time.Hour,
+}
+
+type throttleState struct {
+	failures	int
+	lastFailure	time.Time
+	blockedUntil	time.Time
+}
+
+// LoginThrottle counts failed attempts per key (an account or an IP) and
+// blocks further attempts with exponential backoff, then a lockout.
+type LoginThrottle struct {
+	policy	ThrottlePolicy
+	mu	sync.Mutex
+	states	map[string]*throttleState
+	pruned	time.Time
+	now	func() time.Time
+}
+
+func NewLoginThrottle(policy ThrottlePolicy) *LoginThrottle {
+	return &LoginThrottle{
+		policy:	policy,
+		states:	make(map[string]*throttleState),
+		now:	time.Now,
+	}
+}
+
+// Check returns how long the caller must wait before key may try again, or
+// zero if it's allowed now.
+func (t *LoginThrottle) Check(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.wait(key, t.now())
+}
+
+func (t *LoginThrottle) Failure(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fail(key, t.now())
+}
+
+// Attempt checks key and, if it's allowed, counts the attempt as a failure
+// in the same locked step, so concurrent attempts can't all get through the
+// free ones before any is recorded. It returns how long to wait if key is
+// blocked, in which case nothing is counted. Call Success or Refund once the
+// attempt turns out to be valid.
+func (t *LoginThrottle) Attempt(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if wait := t.wait(key, now); wait > 0 {
+		return wait
+	}
+	t.fail(key, now)
+	return 0
+}
+
+func (t *LoginThrottle) Success(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, key)
+}
+
+// Refund takes back one attempt counted by Attempt without forgetting the
+// rest, for keys like IPs that shouldn't be reset by a single success
+func (t *LoginThrottle) Refund(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.states[key]
+	if !ok {
+		return
+	}
+	state.failures--
+	if state.failures <= 0 {
+		delete(t.states, key)
+		return
+	}
+	if state.failures <= t.policy.FreeAttempts {
+		state.blockedUntil = time.Time{}
+	}
+}
+
+func (t *LoginThrottle) wait(key string, now time.Time) time.Duration {
+	state, ok := t.states[key]
+	if !ok {
+		return 0
+	}
+	if now.Sub(state.lastFailure) > t.policy.ResetAfter {
+		delete(t.states, key)
+		return 0
+	}
+	if now.Before(state.blockedUntil) {
+		return state.blockedUntil.Sub(now)
+	}
+	return 0
+}
+
+func (t *LoginThrottle) fail(key string, now time.Time) {
+	t.prune(now)
+
+	state, ok := t.states[key]
+	if !ok {
+		state = &throttleState{}
+		t.states[key] = state
+	}
+	state.failures++
+	state.lastFailure = now
+
+	switch {
+	case state.failures >= t.policy.LockoutAfter:
+		state.blockedUntil = now.Add(t.policy.LockoutDuration)
+	case state.failures > t.policy.FreeAttempts:
+		delay := t.policy.BaseDelay << (state.failures - t.policy.FreeAttempts - 1)
+		if delay > t.policy.MaxDelay || delay <= 0 {
+			delay = t.policy.MaxDelay
+		}
+		state.blockedUntil = now.Add(delay)
+	}
+}
+
+// prune drops forgotten keys so the map doesn't grow without bound
+func (t *LoginThrottle) prune(now time.Time) {
+	if now.Sub(t.pruned) < time.Minute {
+		return
+	}
+	t.pruned = now
+	for key, state := range t.states {
+		if now.Sub(state.lastFailure) > t.policy.ResetAfter && now.After(state.blockedUntil) {
+			delete(t.states, key)
+		}
+	}
+}
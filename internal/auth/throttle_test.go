@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	policy := ThrottlePolicy{
+		FreeAttempts:		2,
+		BaseDelay:		time.Second,
+		MaxDelay:		4 * time.Second,
+		LockoutAfter:		6,
+		LockoutDuration:	time.Hour,
+		ResetAfter:		24 * time.Hour,
+	}
+
+	tests := []struct {
+		name		string
+		failures	int
+		wantWait	time.Duration
+	}{
+		{
+			name:		"No failures",
+			failures:	0,
+			wantWait:	0,
+		},
+		{
+			name:		"Within free attempts",
+			failures:	2,
+			wantWait:	0,
+		},
+		{
+			name:		"First delayed attempt",
+			failures:	3,
+			wantWait:	time.Second,
+		},
+		{
+			name:		"Delay doubles",
+			failures:	4,
+			wantWait:	2 * time.Second,
+		},
+		{
+			name:		"Delay capped",
+			failures:	5,
+			wantWait:	4 * time.Second,
+		},
+		{
+			name:		"Locked out",
+			failures:	6,
+			wantWait:	time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			throttle := NewLoginThrottle(policy)
+			throttle.now = func() time.Time { return now }
+
+			for i := 0; i < tt.failures; i++ {
+				throttle.Failure("user@example.com")
+			}
+			if wait := throttle.Check("user@example.com"); wait != tt.wantWait {
+				t.Errorf("Check() = %v, wantWait %v", wait, tt.wantWait)
+			}
+			if wait := throttle.Check("other@example.com"); wait != 0 {
+				t.Errorf("Check() other key = %v, want 0", wait)
+			}
+
+			throttle.Success("user@example.com")
+			if wait := throttle.Check("user@example.com"); wait != 0 {
+				t.Errorf("Check() after success = %v, want 0", wait)
+			}
+		})
+	}
+}
+
+func TestLoginThrottleAttempt(t *testing.T) {
+	policy := ThrottlePolicy{
+		FreeAttempts:		3,
+		BaseDelay:		time.Second,
+		MaxDelay:		time.Minute,
+		LockoutAfter:		10,
+		LockoutDuration:	time.Hour,
+		ResetAfter:		24 * time.Hour,
+	}
+	now := time.Unix(1000, 0)
+	throttle := NewLoginThrottle(policy)
+	throttle.now = func() time.Time { return now }
+
+	// Attempts count before their outcome is known, so a burst only gets the
+	// free attempts and the one that starts the backoff through
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if throttle.Attempt("user@example.com") == 0 {
+			allowed++
+		}
+	}
+	if allowed != policy.FreeAttempts + 1 {
+		t.Errorf("Attempt() allowed %d of a burst, want %d", allowed, policy.FreeAttempts + 1)
+	}
+
+	throttle.Success("user@example.com")
+	if wait := throttle.Attempt("user@example.com"); wait != 0 {
+		t.Errorf("Attempt() after success = %v, want 0", wait)
+	}
+
+	for i := 0; i < policy.FreeAttempts; i++ {
+		throttle.Attempt("10.0.0.1")
+		throttle.Refund("10.0.0.1")
+	}
+	if wait := throttle.Check("10.0.0.1"); wait != 0 {
+		t.Errorf("Check() after refunded attempts = %v, want 0", wait)
+	}
+	throttle.Refund("unknown")
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// RFC 6238 appendix B test secret "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		name		string
+		code		string
+		time		time.Time
+		wantStep	int64
+		wantOK		bool
+	}{
+		{
+			name:		"RFC vector at 59s",
+			code:		"287082",
+			time:		time.Unix(59, 0),
+			wantStep:	1,
+			wantOK:		true,
+		},
+		{
+			name:		"RFC vector at 1111111109s",
+			code:		"081804",
+			time:		time.Unix(1111111109, 0),
+			wantStep:	37037036,
+			wantOK:		true,
+		},
+		{
+			name:		"Previous step accepted for clock drift",
+			code:		"287082",
+			time:		time.Unix(89, 0),
+			wantStep:	1,
+			wantOK:		true,
+		},
+		{
+			name:		"Code too old",
+			code:		"287082",
+			time:		time.Unix(119, 0),
+			wantOK:		false,
+		},
+		{
+			name:		"Wrong length",
+			code:		"28708",
+			time:		time.Unix(59, 0),
+			wantOK:		false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok, err := ValidateTOTP(secret, tt.code, tt.time)
+			if err != nil {
+				t.Errorf("ValidateTOTP() error = %v", err)
+				return
+			}
+			if ok != tt.wantOK {
+				t.Errorf("ValidateTOTP() ok = %v, wantOK %v", ok, tt.wantOK)
+				return
+			}
+			if ok && step != tt.wantStep {
+				t.Errorf("ValidateTOTP() step = %v, wantStep %v", step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestMakeRecoveryCodes(t *testing.T) {
+	codes, err := MakeRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("MakeRecoveryCodes() error = %v", err)
+	}
+	format := regexp.MustCompile(`^[0-9a-f]{5}-[0-9a-f]{5}-[0-9a-f]{5}-[0-9a-f]{5}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("MakeRecoveryCodes() code %q isn't xxxxx-xxxxx-xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("MakeRecoveryCodes() repeated code %q", code)
+		}
+		seen[code] = true
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignWebhook returns the hex HMAC-SHA256 of a webhook body. The timestamp is
+// signed along with the body so a captured request can't be replayed later
+// with a fresh timestamp.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSignatureHeader formats a signature header value such as
+// "t=1700000000,v1=5257a869..."
+func WebhookSignatureHeader(secret string, timestamp time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), SignWebhook(secret, timestamp, body))
+}
+
+// VerifyWebhookSignature checks a header from WebhookSignatureHeader against
+// the raw body. Any of secrets may match, so senders can move to a new
+// secret while the old one is still accepted. The header may carry several
+// v1 signatures for the same reason. Timestamps further than tolerance from
+// now are rejected to stop replays.
+func VerifyWebhookSignature(secrets []string, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid signature timestamp")
+			}
+			timestamp = ts
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return fmt.Errorf("malformed signature header")
+	}
+
+	signedAt := time.Unix(timestamp, 0)
+	if now.Sub(signedAt) > tolerance || signedAt.Sub(now) > tolerance {
+		return fmt.Errorf("signature timestamp outside tolerance")
+	}
+
+	for _, secret := range secrets {
+		expected := SignWebhook(secret, signedAt, body)
+		for _, signature := range signatures {
+			if hmac.Equal([]byte(expected), []byte(signature)) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no matching signature")
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	valid := WebhookSignatureHeader("newsecret", now, body)
+
+	tests := []struct {
+		name		string
+		secrets		[]string
+		header		string
+		body		[]byte
+		now		time.Time
+		wantErr		bool
+	}{
+		{
+			name:		"Valid signature",
+			secrets:	[]string{"newsecret"},
+			header:		valid,
+			body:		body,
+			now:		now,
+			wantErr:	false,
+		},
+		{
+			name:		"Matches second secret during rotation",
+			secrets:	[]string{"oldsecret", "newsecret"},
+			header:		valid,
+			body:		body,
+			now:		now,
+			wantErr:	false,
+		},
+		{
+			name:		"One of several signatures matches",
+			secrets:	[]string{"newsecret"},
+			header:		valid + ",v1=" + SignWebhook("othersecret", now, body),
+			body:		body,
+			now:		now,
+			wantErr:	false,
+		},
+		{
+			name:		"Wrong secret",
+			secrets:	[]string{"oldsecret"},
+			header:		valid,
+			body:		body,
+			now:		now,
+			wantErr:	true,
+		},
+		{
+			name:		"Tampered body",
+			secrets:	[]string{"newsecret"},
+			header:		valid,
+			body:		[]byte(`{"event":"user.upgraded","data":{"user_id":"00000000-0000-0000-0000-000000000000"}}`),
+			now:		now,
+			wantErr:	true,
+		},
+		{
+			name:		"Replayed after tolerance",
+			secrets:	[]string{"newsecret"},
+			header:		valid,
+			body:		body,
+			now:		now.Add(6 * time.Minute),
+			wantErr:	true,
+		},
+		{
+			name:		"Timestamp in the future",
+			secrets:	[]string{"newsecret"},
+			header:		valid,
+			body:		body,
+			now:		now.Add(-6 * time.Minute),
+			wantErr:	true,
+		},
+		{
+			name:		"Missing timestamp",
+			secrets:	[]string{"newsecret"},
+			header:		"v1=" + SignWebhook("newsecret", now, body),
+			body:		body,
+			now:		now,
+			wantErr:	true,
+		},
+		{
+			name:		"Empty header",
+			secrets:	[]string{"newsecret"},
+			header:		"",
+			body:		body,
+			now:		now,
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyWebhookSignature(tt.secrets, tt.header, tt.body, 5 * time.Minute, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyWebhookSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+// Package config loads Chirpy's settings from the environment and an
+// optional YAML or TOML file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MinJWTSecretLength	= 32
+	MinAdminAPIKeyLength	= 16
+	redacted		= "[REDACTED]"
+)
+
+// Config holds every setting. Each field is read from the environment
+// variable in its env tag, or from the same name in lower case in the config
+// file. Environment variables win over the file, and the file over defaults.
+type Config struct {
+	Platform		string		`env:"PLATFORM"`
+	// debug, info, warn or error
+	LogLevel		slog.Level	`env:"LOG_LEVEL" default:"info"`
+	Port			int		`env:"PORT" default:"8080"`
+	FilepathRoot		string		`env:"FILEPATH_ROOT" default:"."`
+	// Defaults to http://localhost:<port>
+	BaseURL			string		`env:"BASE_URL"`
+	DBURL			string		`env:"DB_URL" secret:"true"`
+	JWTSecret		string		`env:"JWT_SECRET" secret:"true"`
+	PolkaKey		string		`env:"POLKA_KEY" secret:"true"`
+	PolkaWebhookSecrets	[]string	`env:"POLKA_WEBHOOK_SECRETS" secret:"true"`
+	AdminAPIKey		string		`env:"ADMIN_API_KEY" secret:"true"`
+
+	PasswordMinLength	int		`env:"PASSWORD_MIN_LENGTH" default:"8"`
+	PasswordMaxLength	int		`env:"PASSWORD_MAX_LENGTH" default:"128"`
+	PasswordMinEntropyBits	float64		`env:"PASSWORD_MIN_ENTROPY_BITS" default:"40"`
+	BreachedPasswordsDir	string		`env:"BREACHED_PASSWORDS_DIR"`
+	Argon2MemoryKiB		uint32		`env:"ARGON2_MEMORY_KIB" default:"65536"`
+	Argon2Iterations	uint32		`env:"ARGON2_ITERATIONS" default:"1"`
+	// Zero uses one thread per CPU
+	Argon2Parallelism	uint8		`env:"ARGON2_PARALLELISM"`
+	// How many hashes may run at once, each holding ARGON2_MEMORY_KIB
+	Argon2MaxConcurrency	int		`env:"ARGON2_MAX_CONCURRENCY" default:"4"`
+
+	SMTPAddr		string		`env:"SMTP_ADDR"`
+	SMTPUsername		string		`env:"SMTP_USERNAME"`
+	SMTPPassword		string		`env:"SMTP_PASSWORD" secret:"true"`
+	MailFrom		string		`env:"MAIL_FROM"`
+	MailLogPath		string		`env:"MAIL_LOG_PATH"`
+
+	// Each provider is configured with OIDC_<NAME>_ISSUER,
+	// OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET
+	OIDCProviderNames	[]string	`env:"OIDC_PROVIDERS"`
+	OIDCProviders		[]OIDCProvider
+
+	AccountDeletionGracePeriod	time.Duration	`env:"ACCOUNT_DELETION_GRACE_PERIOD" default:"720h"`
+	EntitlementsFile		string		`env:"ENTITLEMENTS_FILE"`
+
+	HTTPReadHeaderTimeout	time.Duration	`env:"HTTP_READ_HEADER_TIMEOUT" default:"5s"`
+	HTTPReadTimeout		time.Duration	`env:"HTTP_READ_TIMEOUT" default:"15s"`
+	HTTPWriteTimeout	time.Duration	`env:"HTTP_WRITE_TIMEOUT" default:"30s"`
+	HTTPIdleTimeout		time.Duration	`env:"HTTP_IDLE_TIMEOUT" default:"2m"`
+	// How long /api/readyz reports unavailable before the server stops
+	// accepting connections, so load balancers can stop sending traffic
+	ShutdownDrainDelay	time.Duration	`env:"SHUTDOWN_DRAIN_DELAY" default:"5s"`
+	// Upper bound on waiting for in-flight requests and background work
+	ShutdownTimeout		time.Duration	`env:"SHUTDOWN_TIMEOUT" default:"30s"`
+}
+
+type OIDCProvider struct {
+	Name		string
+	Issuer		string
+	ClientID	string
+	ClientSecret	string
+}
+
+// Load reads the config file at path, if there is one, then overrides it
+// with anything set in the environment. Keys in the file that don't match a
+// setting are an error, so a typo can't quietly leave a default in place. The
+// result hasn't been validated.
+func Load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	values := map[string]string{}
+	if path != "" {
+		var err error
+		values, err = readFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Every file key that's looked up is a known one
+	used := map[string]bool{}
+	// An empty variable counts as unset, as it always has for Chirpy
+	lookup := func(key string) (string, bool) {
+		used[key] = true
+		if value, ok := lookupEnv(key); ok && value != "" {
+			return value, true
+		}
+		value, ok := values[key]
+		return value, ok
+	}
+
+	cfg := &Config{}
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		value, ok := lookup(key)
+		if !ok || value == "" {
+			value = field.Tag.Get("default")
+		}
+		if value == "" {
+			continue
+		}
+		err := setField(v.Field(i), value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "http://localhost:" + strconv.Itoa(cfg.Port)
+	}
+	for _, name := range cfg.OIDCProviderNames {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer, _ := lookup(prefix + "ISSUER")
+		clientID, _ := lookup(prefix + "CLIENT_ID")
+		clientSecret, _ := lookup(prefix + "CLIENT_SECRET")
+		cfg.OIDCProviders = append(cfg.OIDCProviders, OIDCProvider{
+			Name:		name,
+			Issuer:		issuer,
+			ClientID:	clientID,
+			ClientSecret:	clientSecret,
+		})
+	}
+
+	unknown := []string{}
+	for key := range values {
+		if !used[key] {
+			unknown = append(unknown, strings.ToLower(key))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown settings in %s: %s", path, strings.Join(unknown, ", "))
+	}
+	return cfg, nil
+}
+
+// readFile flattens a YAML or TOML file into the same keys as the
+// environment, so nested tables like [oidc.google] with issuer = "..."
+// become OIDC_GOOGLE_ISSUER
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", raw, values)
+	return values, nil
+}
+
+func flatten(prefix string, raw map[string]any, values map[string]string) {
+	for key, value := range raw {
+		key = strings.ToUpper(prefix + key)
+		switch value := value.(type) {
+		case map[string]any:
+			flatten(key + "_", value, values)
+		case []any:
+			items := []string{}
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	if field.Type() == reflect.TypeOf(slog.Level(0)) {
+		var level slog.Level
+		err := level.UnmarshalText([]byte(value))
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(level))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Uint8, reflect.Uint32:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate reports every problem with the config at once
+func (c *Config) Validate() error {
+	var errs []error
+	if c.DBURL == "" {
+		errs = append(errs, errors.New("DB_URL must be set"))
+	}
+	if len(c.JWTSecret) < MinJWTSecretLength {
+		errs = append(errs, fmt.Errorf("JWT_SECRET must be at least %d characters", MinJWTSecretLength))
+	}
+	if c.AdminAPIKey != "" && len(c.AdminAPIKey) < MinAdminAPIKeyLength {
+		errs = append(errs, fmt.Errorf("ADMIN_API_KEY must be at least %d characters", MinAdminAPIKeyLength))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, errors.New("PORT must be between 1 and 65535"))
+	}
+	if u, err := url.Parse(c.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, errors.New("BASE_URL must be an absolute URL"))
+	}
+	if c.PasswordMinLength < 1 || c.PasswordMaxLength < c.PasswordMinLength {
+		errs = append(errs, errors.New("PASSWORD_MIN_LENGTH must be positive and no more than PASSWORD_MAX_LENGTH"))
+	}
+	_, err := auth.NewPasswordParams(c.Argon2MemoryKiB, c.Argon2Iterations, c.Argon2Parallelism)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM are invalid: %w", err))
+	}
+	if c.Argon2MaxConcurrency < 1 {
+		errs = append(errs, errors.New("ARGON2_MAX_CONCURRENCY must be at least 1"))
+	}
+	if c.AccountDeletionGracePeriod < 0 {
+		errs = append(errs, errors.New("ACCOUNT_DELETION_GRACE_PERIOD can't be negative"))
+	}
+	if c.HTTPReadHeaderTimeout <= 0 || c.HTTPReadTimeout <= 0 || c.HTTPWriteTimeout <= 0 || c.HTTPIdleTimeout <= 0 {
+		errs = append(errs, errors.New("HTTP_READ_HEADER_TIMEOUT, HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT must be positive"))
+	}
+	if c.ShutdownDrainDelay < 0 || c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("SHUTDOWN_DRAIN_DELAY can't be negative and SHUTDOWN_TIMEOUT must be positive"))
+	}
+	for _, p := range c.OIDCProviders {
+		if p.Issuer == "" || p.ClientID == "" {
+			prefix := "OIDC_" + strings.ToUpper(p.Name) + "_"
+			errs = append(errs, fmt.Errorf("%sISSUER and %sCLIENT_ID must be set", prefix, prefix))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted lists every setting as KEY=value, with secrets hidden, for
+// --print-config
+func (c *Config) Redacted() string {
+	lines := []string{}
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		value := formatField(v.Field(i))
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = redacted
+		}
+		lines = append(lines, key + "=" + value)
+	}
+	for _, p := range c.OIDCProviders {
+		prefix := "OIDC_" + strings.ToUpper(p.Name) + "_"
+		secret := ""
+		if p.ClientSecret != "" {
+			secret = redacted
+		}
+		lines = append(lines,
+			prefix + "ISSUER=" + p.Issuer,
+			prefix + "CLIENT_ID=" + p.ClientID,
+			prefix + "CLIENT_SECRET=" + secret,
+		)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func formatField(field reflect.Value) string {
+	switch value := field.Interface().(type) {
+	case time.Duration:
+		return value.String()
+	case []string:
+		return strings.Join(value, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -7,10 +7,39 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const anonymizeUserChirps = `-- name: AnonymizeUserChirps :exec
+UPDATE chirps
+SET user_id = NULL, updated_at = NOW()
+WHERE user_id = $1
+`
+
+func (q *Queries) AnonymizeUserChirps(ctx context.Context, userID uuid.NullUUID) error {
+	_, err := q.db.ExecContext(ctx, anonymizeUserChirps, userID)
+	return err
+}
+
+const countUserChirpsSince = `-- name: CountUserChirpsSince :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1 AND created_at > $2
+`
+
+type CountUserChirpsSinceParams struct {
+	UserID    uuid.NullUUID
+	CreatedAt time.Time
+}
+
+func (q *Queries) CountUserChirpsSince(ctx context.Context, arg CountUserChirpsSinceParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUserChirpsSince, arg.UserID, arg.CreatedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createChirp = `-- name: CreateChirp :one
 INSERT INTO chirps(
 	id,
@@ -31,7 +60,7 @@ RETURNING id, created_at, updated_at, body, user_id
 
 type CreateChirpParams struct {
 	Body   string
-	UserID uuid.UUID
+	UserID uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
@@ -54,7 +83,7 @@ WHERE id = $1 AND user_id = $2
 
 type DeleteChirpParams struct {
 	ID     uuid.UUID
-	UserID uuid.UUID
+	UserID uuid.NullUUID
 }
 
 func (q *Queries) DeleteChirp(ctx context.Context, arg DeleteChirpParams) error {
@@ -120,7 +149,7 @@ WHERE user_id = $1
 ORDER BY created_at ASC
 `
 
-func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.NullUUID) ([]Chirp, error) {
 	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, userID)
 	if err != nil {
 		return nil, err
@@ -148,3 +177,29 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $3, updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING id, created_at, updated_at, body, user_id
+`
+
+type UpdateChirpBodyParams struct {
+	ID     uuid.UUID
+	UserID uuid.NullUUID
+	Body   string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.UserID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+	)
+	return i, err
+}
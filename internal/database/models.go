@@ -11,21 +11,131 @@ import (
 	"github.com/google/uuid"
 )
 
+type ApiToken struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scope      string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Body      string
+	UserID    uuid.NullUUID
+}
+
+type DataExport struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UserID      uuid.UUID
+	Status      string
+	Archive     []byte
+	CompletedAt sql.NullTime
+	ExpiresAt   sql.NullTime
+}
+
+type DeniedToken struct {
+	Jti       string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type MagicLinkToken struct {
+	TokenHash string
+	CreatedAt time.Time
 	UserID    uuid.UUID
+	NonceHash string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
 }
 
-type RefreshToken struct {
-	Token     string
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	UserID       uuid.UUID
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris []string
+	Scopes       string
+}
+
+type Outbox struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	EventType     string
+	UserID        uuid.NullUUID
+	Payload       string
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+	PublishedAt   sql.NullTime
+}
+
+type PasswordResetToken struct {
+	TokenHash string
 	CreatedAt time.Time
-	UpdatedAt time.Time
 	UserID    uuid.UUID
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
+}
+
+type RecoveryCode struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	HashedCode string
+	UsedAt     sql.NullTime
+}
+
+type RefreshToken struct {
+	Token                string
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	UserID               uuid.UUID
+	ExpiresAt            time.Time
+	RevokedAt            sql.NullTime
+	AccessTokenJti       sql.NullString
+	AccessTokenExpiresAt sql.NullTime
+	ClientID             sql.NullString
+	Scope                sql.NullString
+}
+
+type Subscription struct {
+	UserID             uuid.UUID
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	Status             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+}
+
+type SubscriptionPeriod struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	StartsAt  time.Time
+	EndsAt    time.Time
+	EventID   sql.NullString
 }
 
 type User struct {
@@ -35,4 +145,64 @@ type User struct {
 	Email           string
 	HashedPasswords string
 	IsChirpyRed     bool
+	TotpSecret      sql.NullString
+	TotpEnabled     bool
+	TotpLastStep    int64
+	EmailVerified   bool
+	PendingEmail    sql.NullString
+	DeleteAfter     sql.NullTime
+	KeepChirps      bool
+}
+
+type UserIdentity struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Issuer    string
+	Subject   string
+}
+
+type WebhookDelivery struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	EndpointID    uuid.UUID
+	EventID       uuid.UUID
+	EventType     string
+	Payload       string
+	Status        string
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastAttemptAt sql.NullTime
+	DeliveredAt   sql.NullTime
+}
+
+type WebhookDeliveryAttempt struct {
+	ID          uuid.UUID
+	DeliveryID  uuid.UUID
+	AttemptedAt time.Time
+	StatusCode  sql.NullInt32
+	Error       sql.NullString
+	DurationMs  int32
+}
+
+type WebhookEndpoint struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.NullUUID
+	Url        string
+	Secret     string
+	EventTypes []string
+}
+
+type WebhookEvent struct {
+	ID          string
+	Source      string
+	EventType   string
+	Payload     string
+	ReceivedAt  time.Time
+	ProcessedAt sql.NullTime
+	Status      string
+	Error       sql.NullString
+	Attempts    int32
 }
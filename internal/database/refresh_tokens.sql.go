@@ -13,6 +13,62 @@ import (
 	"github.com/google/uuid"
 )
 
+const createClientRefreshToken = `-- name: CreateClientRefreshToken :one
+INSERT INTO refresh_tokens(
+	token,
+	created_at,
+	updated_at,
+	user_id,
+	expires_at,
+	revoked_at,
+	client_id,
+	scope
+)
+VALUES (
+	$1,
+	NOW(),
+	NOW(),
+	$2,
+	$3,
+	NULL,
+	$4,
+	$5
+)
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, access_token_jti, access_token_expires_at, client_id, scope
+`
+
+type CreateClientRefreshTokenParams struct {
+	Token     string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	ClientID  sql.NullString
+	Scope     sql.NullString
+}
+
+func (q *Queries) CreateClientRefreshToken(ctx context.Context, arg CreateClientRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, createClientRefreshToken,
+		arg.Token,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.ClientID,
+		arg.Scope,
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.AccessTokenJti,
+		&i.AccessTokenExpiresAt,
+		&i.ClientID,
+		&i.Scope,
+	)
+	return i, err
+}
+
 const createRefreshToken = `-- name: CreateRefreshToken :one
 INSERT INTO refresh_tokens(
 	token,
@@ -30,7 +86,7 @@ VALUES (
 	$3,
 	NULL
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, access_token_jti, access_token_expires_at, client_id, scope
 `
 
 type CreateRefreshTokenParams struct {
@@ -49,21 +105,29 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.AccessTokenJti,
+		&i.AccessTokenExpiresAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
 
 const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT token, user_id, expires_at, revoked_at
+SELECT token, user_id, expires_at, revoked_at, access_token_jti, access_token_expires_at, client_id, scope
 FROM refresh_tokens
 WHERE token = $1
 `
 
 type GetUserFromRefreshTokenRow struct {
-	Token     string
-	UserID    uuid.UUID
-	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	Token                string
+	UserID               uuid.UUID
+	ExpiresAt            time.Time
+	RevokedAt            sql.NullTime
+	AccessTokenJti       sql.NullString
+	AccessTokenExpiresAt sql.NullTime
+	ClientID             sql.NullString
+	Scope                sql.NullString
 }
 
 func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (GetUserFromRefreshTokenRow, error) {
@@ -74,10 +138,112 @@ func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (Ge
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.AccessTokenJti,
+		&i.AccessTokenExpiresAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
 
+const listUserRefreshTokens = `-- name: ListUserRefreshTokens :many
+SELECT created_at, updated_at, expires_at, revoked_at, client_id, scope
+FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at ASC
+`
+
+type ListUserRefreshTokensRow struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt sql.NullTime
+	ClientID  sql.NullString
+	Scope     sql.NullString
+}
+
+func (q *Queries) ListUserRefreshTokens(ctx context.Context, userID uuid.UUID) ([]ListUserRefreshTokensRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUserRefreshTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUserRefreshTokensRow
+	for rows.Next() {
+		var i ListUserRefreshTokensRow
+		if err := rows.Scan(
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.ClientID,
+			&i.Scope,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :many
+UPDATE refresh_tokens
+SET revoked_at = NOW(), expires_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+RETURNING access_token_jti, access_token_expires_at
+`
+
+type RevokeUserRefreshTokensRow struct {
+	AccessTokenJti       sql.NullString
+	AccessTokenExpiresAt sql.NullTime
+}
+
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) ([]RevokeUserRefreshTokensRow, error) {
+	rows, err := q.db.QueryContext(ctx, revokeUserRefreshTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RevokeUserRefreshTokensRow
+	for rows.Next() {
+		var i RevokeUserRefreshTokensRow
+		if err := rows.Scan(&i.AccessTokenJti, &i.AccessTokenExpiresAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateRefreshTokenAccessToken = `-- name: UpdateRefreshTokenAccessToken :exec
+UPDATE refresh_tokens
+SET access_token_jti = $2, access_token_expires_at = $3, updated_at = NOW()
+WHERE token = $1
+`
+
+type UpdateRefreshTokenAccessTokenParams struct {
+	Token                string
+	AccessTokenJti       sql.NullString
+	AccessTokenExpiresAt sql.NullTime
+}
+
+func (q *Queries) UpdateRefreshTokenAccessToken(ctx context.Context, arg UpdateRefreshTokenAccessTokenParams) error {
+	_, err := q.db.ExecContext(ctx, updateRefreshTokenAccessToken, arg.Token, arg.AccessTokenJti, arg.AccessTokenExpiresAt)
+	return err
+}
+
 const updateTokenRevokedAt = `-- name: UpdateTokenRevokedAt :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), expires_at = NOW(), updated_at = NOW()
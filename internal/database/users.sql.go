@@ -7,11 +7,45 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :execrows
+UPDATE users
+SET delete_after = NULL, keep_chirps = FALSE, updated_at = NOW()
+WHERE id = $1 AND delete_after IS NOT NULL
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelUserDeletion, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const confirmUserPendingEmail = `-- name: ConfirmUserPendingEmail :execrows
+UPDATE users
+SET email = pending_email, pending_email = NULL, email_verified = TRUE, updated_at = NOW()
+WHERE id = $1 AND pending_email = $2
+`
+
+type ConfirmUserPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) ConfirmUserPendingEmail(ctx context.Context, arg ConfirmUserPendingEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, confirmUserPendingEmail, arg.ID, arg.PendingEmail)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users(
 	id, 
@@ -27,7 +61,7 @@ VALUES (
 	$1,
 	$2
 )
-RETURNING id, created_at, updated_at, email, is_chirpy_red
+RETURNING id, created_at, updated_at, email, is_chirpy_red, email_verified
 `
 
 type CreateUserParams struct {
@@ -36,11 +70,12 @@ type CreateUserParams struct {
 }
 
 type CreateUserRow struct {
-	ID          uuid.UUID
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	Email       string
-	IsChirpyRed bool
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Email         string
+	IsChirpyRed   bool
+	EmailVerified bool
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error) {
@@ -52,12 +87,42 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 		&i.UpdatedAt,
 		&i.Email,
 		&i.IsChirpyRed,
+		&i.EmailVerified,
 	)
 	return i, err
 }
 
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1 AND delete_after <= NOW()
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enableUserTOTP = `-- name: EnableUserTOTP :exec
+UPDATE users
+SET totp_enabled = TRUE, totp_last_step = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type EnableUserTOTPParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+}
+
+func (q *Queries) EnableUserTOTP(ctx context.Context, arg EnableUserTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, enableUserTOTP, arg.ID, arg.TotpLastStep)
+	return err
+}
+
 const getUser = `-- name: GetUser :one
-SELECT id, created_at, updated_at, email, hashed_passwords, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_passwords, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, pending_email, delete_after, keep_chirps FROM users
 WHERE email = $1
 `
 
@@ -71,10 +136,125 @@ func (q *Queries) GetUser(ctx context.Context, email string) (User, error) {
 		&i.Email,
 		&i.HashedPasswords,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.PendingEmail,
+		&i.DeleteAfter,
+		&i.KeepChirps,
 	)
 	return i, err
 }
 
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_passwords, is_chirpy_red, totp_secret, totp_enabled, totp_last_step, email_verified, pending_email, delete_after, keep_chirps FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPasswords,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerified,
+		&i.PendingEmail,
+		&i.DeleteAfter,
+		&i.KeepChirps,
+	)
+	return i, err
+}
+
+const getUsersDueForDeletion = `-- name: GetUsersDueForDeletion :many
+SELECT id, keep_chirps FROM users
+WHERE delete_after <= NOW()
+`
+
+type GetUsersDueForDeletionRow struct {
+	ID         uuid.UUID
+	KeepChirps bool
+}
+
+func (q *Queries) GetUsersDueForDeletion(ctx context.Context) ([]GetUsersDueForDeletionRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersDueForDeletion)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUsersDueForDeletionRow
+	for rows.Next() {
+		var i GetUsersDueForDeletionRow
+		if err := rows.Scan(&i.ID, &i.KeepChirps); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET delete_after = $2, keep_chirps = $3, updated_at = NOW()
+WHERE id = $1
+`
+
+type ScheduleUserDeletionParams struct {
+	ID          uuid.UUID
+	DeleteAfter sql.NullTime
+	KeepChirps  bool
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) error {
+	_, err := q.db.ExecContext(ctx, scheduleUserDeletion, arg.ID, arg.DeleteAfter, arg.KeepChirps)
+	return err
+}
+
+const setUserChirpyRed = `-- name: SetUserChirpyRed :exec
+UPDATE users
+SET is_chirpy_red = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserChirpyRedParams struct {
+	ID          uuid.UUID
+	IsChirpyRed bool
+}
+
+func (q *Queries) SetUserChirpyRed(ctx context.Context, arg SetUserChirpyRedParams) error {
+	_, err := q.db.ExecContext(ctx, setUserChirpyRed, arg.ID, arg.IsChirpyRed)
+	return err
+}
+
+const setUserPendingEmail = `-- name: SetUserPendingEmail :exec
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) SetUserPendingEmail(ctx context.Context, arg SetUserPendingEmailParams) error {
+	_, err := q.db.ExecContext(ctx, setUserPendingEmail, arg.ID, arg.PendingEmail)
+	return err
+}
+
 const truncateUsers = `-- name: TruncateUsers :exec
 TRUNCATE TABLE users CASCADE
 `
@@ -84,30 +264,72 @@ func (q *Queries) TruncateUsers(ctx context.Context) error {
 	return err
 }
 
-const updateUserAddChirpyRed = `-- name: UpdateUserAddChirpyRed :exec
+const updateUserPassword = `-- name: UpdateUserPassword :exec
 UPDATE users
-SET is_chirpy_red = TRUE 
+SET hashed_passwords = $2, updated_at = NOW()
 WHERE id = $1
 `
 
-func (q *Queries) UpdateUserAddChirpyRed(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, updateUserAddChirpyRed, id)
+type UpdateUserPasswordParams struct {
+	ID              uuid.UUID
+	HashedPasswords string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPasswords)
 	return err
 }
 
-const updateUserCredentials = `-- name: UpdateUserCredentials :exec
+const updateUserTOTPLastStep = `-- name: UpdateUserTOTPLastStep :execrows
 UPDATE users
-SET email = $1, hashed_passwords = $2, updated_at = NOW()
-WHERE id = $3
+SET totp_last_step = $2
+WHERE id = $1 AND totp_last_step < $2
 `
 
-type UpdateUserCredentialsParams struct {
-	Email           string
-	HashedPasswords string
-	ID              uuid.UUID
+type UpdateUserTOTPLastStepParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
 }
 
-func (q *Queries) UpdateUserCredentials(ctx context.Context, arg UpdateUserCredentialsParams) error {
-	_, err := q.db.ExecContext(ctx, updateUserCredentials, arg.Email, arg.HashedPasswords, arg.ID)
+func (q *Queries) UpdateUserTOTPLastStep(ctx context.Context, arg UpdateUserTOTPLastStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateUserTOTPLastStep, arg.ID, arg.TotpLastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateUserTOTPSecret = `-- name: UpdateUserTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2, totp_enabled = FALSE, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+}
+
+func (q *Queries) UpdateUserTOTPSecret(ctx context.Context, arg UpdateUserTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserTOTPSecret, arg.ID, arg.TotpSecret)
 	return err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified = TRUE, updated_at = NOW()
+WHERE id = $1 AND email = $2
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,139 @@
+// Package entitlements maps subscription plans to the limits users on them
+// get.
+package entitlements
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	PlanFree	= "free"
+	PlanChirpyRed	= "chirpy_red"
+	// Unlimited lifts a plan's chirps_per_hour limit. It has to be spelled
+	// out, so a limit left out of a plan file never means unlimited.
+	Unlimited	= -1
+)
+
+type Plan struct {
+	Name		string		`json:"name"`
+	ChirpMaxLength	int		`json:"chirp_max_length"`
+	// How long after posting a chirp can be edited, zero if it can't
+	EditWindow	Duration	`json:"edit_window"`
+	// Unlimited (-1) for no limit
+	ChirpsPerHour	int		`json:"chirps_per_hour"`
+}
+
+var DefaultPlans = map[string]Plan{
+	PlanFree: {
+		ChirpMaxLength:	140,
+		ChirpsPerHour:	30,
+	},
+	PlanChirpyRed: {
+		ChirpMaxLength:	280,
+		EditWindow:	Duration(15 * time.Minute),
+		ChirpsPerHour:	300,
+	},
+}
+
+// Duration is a time.Duration written as a string like "15m" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadPlans reads plan definitions from a JSON file keyed by plan name.
+// Plans missing from the file keep their defaults, and so do fields missing
+// from a plan. A plan that isn't one of the defaults starts from the free
+// plan. Unknown fields are an error, so a misspelled limit isn't ignored.
+func LoadPlans(path string) (map[string]Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]json.RawMessage{}
+	err = json.Unmarshal(data, &loaded)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	plans := map[string]Plan{}
+	for name, plan := range DefaultPlans {
+		plans[name] = plan
+	}
+	for name, raw := range loaded {
+		plan, ok := DefaultPlans[name]
+		if !ok {
+			plan = DefaultPlans[PlanFree]
+		}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&plan)
+		if err != nil {
+			return nil, fmt.Errorf("parsing plan %q in %s: %w", name, path, err)
+		}
+		if plan.ChirpMaxLength <= 0 {
+			return nil, fmt.Errorf("plan %q: chirp_max_length must be positive", name)
+		}
+		if plan.EditWindow < 0 {
+			return nil, fmt.Errorf("plan %q: edit_window can't be negative", name)
+		}
+		if plan.ChirpsPerHour < 0 && plan.ChirpsPerHour != Unlimited {
+			return nil, fmt.Errorf("plan %q: chirps_per_hour must be %d for unlimited or not negative", name, Unlimited)
+		}
+		plans[name] = plan
+	}
+	return plans, nil
+}
+
+// PlanLookup returns the name of the plan a user is on
+type PlanLookup func(ctx context.Context, userID uuid.UUID) (string, error)
+
+type Service struct {
+	plans	map[string]Plan
+	lookup	PlanLookup
+}
+
+func New(plans map[string]Plan, lookup PlanLookup) *Service {
+	return &Service{
+		plans:	plans,
+		lookup:	lookup,
+	}
+}
+
+// For returns the plan a user is on. A plan name with no definition falls
+// back to the free plan, so a typo in config can't grant unlimited access.
+func (s *Service) For(ctx context.Context, userID uuid.UUID) (Plan, error) {
+	name, err := s.lookup(ctx, userID)
+	if err != nil {
+		return Plan{}, err
+	}
+	plan, ok := s.plans[name]
+	if !ok {
+		name = PlanFree
+		plan = s.plans[PlanFree]
+	}
+	plan.Name = name
+	return plan, nil
+}
@@ -0,0 +1,162 @@
+package entitlements
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLoadPlans(t *testing.T) {
+	tests := []struct {
+		name		string
+		config		string
+		wantFree	Plan
+		wantRed		Plan
+		wantErr		bool
+	}{
+		{
+			name:		"Overrides one plan",
+			config:		`{"chirpy_red": {"chirp_max_length": 500, "edit_window": "1h"}}`,
+			wantFree:	DefaultPlans[PlanFree],
+			wantRed: Plan{
+				ChirpMaxLength:	500,
+				EditWindow:	Duration(time.Hour),
+				ChirpsPerHour:	300,
+			},
+		},
+		{
+			name:	"Partial override keeps the other limits",
+			config:	`{"free": {"chirp_max_length": 200}}`,
+			wantFree: Plan{
+				ChirpMaxLength:	200,
+				ChirpsPerHour:	30,
+			},
+			wantRed:	DefaultPlans[PlanChirpyRed],
+		},
+		{
+			name:		"Explicitly unlimited",
+			config:		`{"chirpy_red": {"chirps_per_hour": -1}}`,
+			wantFree:	DefaultPlans[PlanFree],
+			wantRed: Plan{
+				ChirpMaxLength:	280,
+				EditWindow:	Duration(15 * time.Minute),
+				ChirpsPerHour:	Unlimited,
+			},
+		},
+		{
+			name:		"Zero chirp length",
+			config:		`{"chirpy_red": {"chirp_max_length": 0}}`,
+			wantErr:	true,
+		},
+		{
+			name:		"Negative edit window",
+			config:		`{"free": {"edit_window": "-1m"}}`,
+			wantErr:	true,
+		},
+		{
+			name:		"Unknown field",
+			config:		`{"free": {"max_media": 4}}`,
+			wantErr:	true,
+		},
+		{
+			name:		"Negative rate that isn't unlimited",
+			config:		`{"free": {"chirps_per_hour": -5}}`,
+			wantErr:	true,
+		},
+		{
+			name:		"Bad duration",
+			config:		`{"free": {"edit_window": "soon"}}`,
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plans.json")
+			err := os.WriteFile(path, []byte(tt.config), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			plans, err := LoadPlans(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPlans() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := plans[PlanFree]; !reflect.DeepEqual(got, tt.wantFree) {
+				t.Errorf("LoadPlans() free = %+v, want %+v", got, tt.wantFree)
+			}
+			if got := plans[PlanChirpyRed]; !reflect.DeepEqual(got, tt.wantRed) {
+				t.Errorf("LoadPlans() chirpy_red = %+v, want %+v", got, tt.wantRed)
+			}
+			if DefaultPlans[PlanChirpyRed].ChirpMaxLength != 280 {
+				t.Errorf("LoadPlans() changed the default plans")
+			}
+		})
+	}
+}
+
+func TestLoadPlansNewPlanStartsFromFree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plans.json")
+	err := os.WriteFile(path, []byte(`{"team": {"chirp_max_length": 1000}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, err := LoadPlans(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plans["team"]; got.ChirpMaxLength != 1000 || got.ChirpsPerHour != DefaultPlans[PlanFree].ChirpsPerHour {
+		t.Errorf("LoadPlans() team = %+v, want the free plan's rate limit", got)
+	}
+}
+
+func TestServiceFor(t *testing.T) {
+	tests := []struct {
+		name		string
+		plan		string
+		wantName	string
+		wantLength	int
+	}{
+		{
+			name:		"Free",
+			plan:		PlanFree,
+			wantName:	PlanFree,
+			wantLength:	140,
+		},
+		{
+			name:		"Chirpy Red",
+			plan:		PlanChirpyRed,
+			wantName:	PlanChirpyRed,
+			wantLength:	280,
+		},
+		{
+			name:		"Unknown plan falls back to free",
+			plan:		"platinum",
+			wantName:	PlanFree,
+			wantLength:	140,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(DefaultPlans, func(ctx context.Context, userID uuid.UUID) (string, error) {
+				return tt.plan, nil
+			})
+			got, err := s.For(context.Background(), uuid.New())
+			if err != nil {
+				t.Fatalf("For() error = %v", err)
+			}
+			if got.Name != tt.wantName || got.ChirpMaxLength != tt.wantLength {
+				t.Errorf("For() = %+v, want %s with length %d", got, tt.wantName, tt.wantLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Package events carries domain events from the outbox to whatever reacts to
+// them.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AllEvents subscribes a handler to every event type
+const AllEvents = "*"
+
+type Event struct {
+	ID		uuid.UUID
+	Type		string
+	// The user the event is about, or uuid.Nil
+	UserID		uuid.UUID
+	CreatedAt	time.Time
+	Payload		json.RawMessage
+}
+
+// Handler reacts to an event. Delivery is at least once, so handlers must
+// cope with seeing the same event ID again.
+type Handler func(ctx context.Context, event Event) error
+
+// EventBus publishes events to subscribers. An error means the event wasn't
+// fully handled and will be published again.
+type EventBus interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// MemoryBus is an EventBus that calls subscribed handlers in process
+type MemoryBus struct {
+	mu		sync.RWMutex
+	handlers	map[string][]Handler
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		handlers:	make(map[string][]Handler),
+	}
+}
+
+func (b *MemoryBus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish calls every handler for the event's type, then those subscribed to
+// all events. Every handler runs even if an earlier one fails, and their
+// errors are returned together.
+func (b *MemoryBus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := append([]Handler{}, b.handlers[event.Type]...)
+	handlers = append(handlers, b.handlers[AllEvents]...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		err := handler(ctx, event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
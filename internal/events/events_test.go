@@ -0,0 +1,72 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryBusPublish(t *testing.T) {
+	tests := []struct {
+		name		string
+		eventType	string
+		failing		bool
+		wantCalls	[]string
+		wantErr		bool
+	}{
+		{
+			name:		"Typed and catch-all handlers",
+			eventType:	"chirp.created",
+			wantCalls:	[]string{"chirp.created", "all"},
+		},
+		{
+			name:		"Only catch-all handler",
+			eventType:	"user.upgraded",
+			wantCalls:	[]string{"all"},
+		},
+		{
+			name:		"Failing handler doesn't stop the others",
+			eventType:	"chirp.created",
+			failing:	true,
+			wantCalls:	[]string{"failing", "chirp.created", "all"},
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := []string{}
+			bus := NewMemoryBus()
+			if tt.failing {
+				bus.Subscribe("chirp.created", func(ctx context.Context, event Event) error {
+					calls = append(calls, "failing")
+					return errors.New("downstream unavailable")
+				})
+			}
+			bus.Subscribe("chirp.created", func(ctx context.Context, event Event) error {
+				calls = append(calls, "chirp.created")
+				return nil
+			})
+			bus.Subscribe(AllEvents, func(ctx context.Context, event Event) error {
+				calls = append(calls, "all")
+				return nil
+			})
+
+			err := bus.Publish(context.Background(), Event{ID: uuid.New(), Type: tt.eventType})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(calls) != len(tt.wantCalls) {
+				t.Fatalf("Publish() called %v, want %v", calls, tt.wantCalls)
+			}
+			for i := range calls {
+				if calls[i] != tt.wantCalls[i] {
+					t.Errorf("Publish() called %v, want %v", calls, tt.wantCalls)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Package health runs the readiness checks behind /api/readyz.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK		= "ok"
+	StatusUnavailable	= "unavailable"
+)
+
+// Check reports whether a dependency is usable. It should give up once ctx
+// is done.
+type Check func(ctx context.Context) error
+
+type CheckResult struct {
+	Status		string	`json:"status"`
+	Error		string	`json:"error,omitempty"`
+	DurationMs	int64	`json:"duration_ms"`
+}
+
+type Report struct {
+	Status	string			`json:"status"`
+	Checks	map[string]CheckResult	`json:"checks"`
+}
+
+// Registry holds the named checks that make up readiness. Subsystems add
+// their own with Register.
+type Registry struct {
+	mu	sync.RWMutex
+	checks	map[string]Check
+	timeout	time.Duration
+}
+
+// NewRegistry returns an empty registry whose checks each get timeout to
+// finish
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{
+		checks:		make(map[string]Check),
+		timeout:	timeout,
+	}
+}
+
+// Register adds a check, replacing any already registered under name
+func (r *Registry) Register(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+// Run runs every check at once and reports ready only if they all pass
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := make(map[string]Check, len(r.checks))
+	for name, check := range r.checks {
+		checks[name] = check
+	}
+	r.mu.RUnlock()
+
+	report := Report{
+		Status:	StatusOK,
+		Checks:	make(map[string]CheckResult, len(checks)),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := r.run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	// A check that ignores ctx still can't hold up the report
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Status:		StatusOK,
+		DurationMs:	time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = StatusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// PingDB checks that a connection to the database can be made
+func PingDB(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// currentMigrationQuery finds goose's current version the way goose does:
+// the highest version whose most recent row was an up migration
+const currentMigrationQuery = `SELECT COALESCE(MAX(version_id), 0)::bigint FROM (
+	SELECT DISTINCT ON (version_id) version_id, is_applied
+	FROM goose_db_version
+	ORDER BY version_id, id DESC
+) latest WHERE is_applied`
+
+// MigrationVersion checks that the database has had every migration this
+// build knows about applied
+func MigrationVersion(db *sql.DB, expected int64) Check {
+	return func(ctx context.Context) error {
+		var current int64
+		err := db.QueryRowContext(ctx, currentMigrationQuery).Scan(&current)
+		if err != nil {
+			return fmt.Errorf("reading migration version: %w", err)
+		}
+		if current < expected {
+			return fmt.Errorf("database is at migration %d, want %d", current, expected)
+		}
+		return nil
+	}
+}
+
+// LatestMigration returns the highest version among goose migration files
+// like 019_outbox.sql in dir
+func LatestMigration(fsys fs.FS, dir string) (int64, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".sql" {
+			continue
+		}
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			continue
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("migration %s: %w", name, err)
+		}
+		latest = max(latest, version)
+	}
+	if latest == 0 {
+		return 0, fmt.Errorf("no migrations found in %s", dir)
+	}
+	return latest, nil
+}
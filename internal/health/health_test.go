@@ -0,0 +1,122 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRegistryRun(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("connection refused") }
+	hanging := func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	tests := []struct {
+		name		string
+		checks		map[string]Check
+		wantStatus	string
+		wantChecks	map[string]string
+	}{
+		{
+			name:		"No checks",
+			checks:		map[string]Check{},
+			wantStatus:	StatusOK,
+			wantChecks:	map[string]string{},
+		},
+		{
+			name:		"All passing",
+			checks:		map[string]Check{"database": ok, "migrations": ok},
+			wantStatus:	StatusOK,
+			wantChecks:	map[string]string{"database": StatusOK, "migrations": StatusOK},
+		},
+		{
+			name:		"One failing",
+			checks:		map[string]Check{"database": failing, "migrations": ok},
+			wantStatus:	StatusUnavailable,
+			wantChecks:	map[string]string{"database": StatusUnavailable, "migrations": StatusOK},
+		},
+		{
+			name:		"Check that ignores the timeout",
+			checks:		map[string]Check{"slow": hanging},
+			wantStatus:	StatusUnavailable,
+			wantChecks:	map[string]string{"slow": StatusUnavailable},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(50 * time.Millisecond)
+			for name, check := range tt.checks {
+				r.Register(name, check)
+			}
+
+			start := time.Now()
+			report := r.Run(context.Background())
+			if elapsed := time.Since(start); elapsed > 500 * time.Millisecond {
+				t.Errorf("Run() took %v, want it bounded by the timeout", elapsed)
+			}
+			if report.Status != tt.wantStatus {
+				t.Errorf("Run() status = %s, want %s", report.Status, tt.wantStatus)
+			}
+			if len(report.Checks) != len(tt.wantChecks) {
+				t.Fatalf("Run() checks = %v, want %v", report.Checks, tt.wantChecks)
+			}
+			for name, want := range tt.wantChecks {
+				got := report.Checks[name]
+				if got.Status != want {
+					t.Errorf("Run() %s = %+v, want %s", name, got, want)
+				}
+				if (got.Error != "") != (want != StatusOK) {
+					t.Errorf("Run() %s error = %q, want one only on failure", name, got.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestLatestMigration(t *testing.T) {
+	tests := []struct {
+		name	string
+		files	fstest.MapFS
+		want	int64
+		wantErr	bool
+	}{
+		{
+			name:	"Highest version wins",
+			files:	fstest.MapFS{
+				"schema/001_users.sql":		{},
+				"schema/019_outbox.sql":	{},
+				"schema/002_chirps.sql":	{},
+				"schema/README.md":		{},
+			},
+			want:	19,
+		},
+		{
+			name:		"Bad version",
+			files:		fstest.MapFS{"schema/first_users.sql": {}},
+			wantErr:	true,
+		},
+		{
+			name:		"No migrations",
+			files:		fstest.MapFS{"schema/README.md": {}},
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LatestMigration(tt.files, "schema")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LatestMigration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("LatestMigration() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To	string
+	Subject	string
+	Body	string
+}
+
+// Mailer delivers transactional email such as password reset links
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends mail through an SMTP relay. Username and Password are
+// optional; when set, PLAIN auth is used.
+type SMTPMailer struct {
+	Addr		string
+	Username	string
+	Password	string
+	From		string
+}
+
+func (m SMTPMailer) Send(ctx context.Context, msg Message) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid smtp address: %s", err)
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, formatMessage(m.From, msg))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("error sending mail: %s", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogMailer writes messages to a file instead of sending them, for local
+// development and tests. With no Path it logs them to Logger (or the default
+// slog logger), leaving the body out unless ShowBody is set, since bodies
+// carry live reset and login tokens.
+type LogMailer struct {
+	Path		string
+	Logger		*slog.Logger
+	ShowBody	bool
+	mu		sync.Mutex
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if m.Path == "" {
+		logger := m.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		args := []any{"to", msg.To, "subject", msg.Subject}
+		if m.ShowBody {
+			args = append(args, "body", msg.Body)
+		}
+		logger.InfoContext(ctx, "Mail not sent, no SMTP relay configured", args...)
+		return nil
+	}
+
+	formatted := fmt.Sprintf("--- %s ---\n%s\n", time.Now().Format(time.RFC3339), formatMessage("chirpy", msg))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening mail log: %s", err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(formatted)
+	if err != nil {
+		return fmt.Errorf("error writing mail log: %s", err)
+	}
+	return nil
+}
+
+// Header values come from user input, so strip line breaks to stop anyone
+// injecting extra headers
+var headerSanitizer = strings.NewReplacer("\r", "", "\n", "")
+
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerSanitizer.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerSanitizer.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerSanitizer.Replace(msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,73 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := &LogMailer{Path: path}
+
+	messages := []Message{
+		{To: "first@example.com", Subject: "Hello", Body: "first body"},
+		{To: "second@example.com", Subject: "Again", Body: "second body"},
+	}
+	for _, msg := range messages {
+		if err := m.Send(context.Background(), msg); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading mail log: %v", err)
+	}
+	for _, msg := range messages {
+		for _, want := range []string{"To: " + msg.To, "Subject: " + msg.Subject, msg.Body} {
+			if !strings.Contains(string(dat), want) {
+				t.Errorf("mail log missing %q", want)
+			}
+		}
+	}
+}
+
+func TestLogMailerWithoutPath(t *testing.T) {
+	msg := Message{To: "user@example.com", Subject: "Reset your password", Body: "token=s3cret"}
+
+	tests := []struct {
+		name		string
+		showBody	bool
+	}{
+		{name: "Body hidden", showBody: false},
+		{name: "Body shown", showBody: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			m := &LogMailer{Logger: slog.New(slog.NewJSONHandler(&buf, nil)), ShowBody: tt.showBody}
+			if err := m.Send(context.Background(), msg); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("log line isn't JSON: %v", err)
+			}
+			if entry["to"] != msg.To || entry["subject"] != msg.Subject {
+				t.Errorf("log entry = %v, want to and subject", entry)
+			}
+			_, hasBody := entry["body"]
+			if hasBody != tt.showBody {
+				t.Errorf("log entry has body = %v, want %v", hasBody, tt.showBody)
+			}
+		})
+	}
+}
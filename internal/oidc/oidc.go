@@ -0,0 +1,321 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Config identifies Chirpy to an OpenID Connect provider
+type Config struct {
+	Issuer		string
+	ClientID	string
+	ClientSecret	string
+	RedirectURL	string
+}
+
+// IDToken holds the claims Chirpy uses from a validated ID token
+type IDToken struct {
+	Issuer		string
+	Subject		string
+	Email		string
+	EmailVerified	bool
+}
+
+type metadata struct {
+	Issuer			string	`json:"issuer"`
+	AuthorizationEndpoint	string	`json:"authorization_endpoint"`
+	TokenEndpoint		string	`json:"token_endpoint"`
+	JWKSURI			string	`json:"jwks_uri"`
+}
+
+// Don't refetch keys more often than this when a token names an unknown kid,
+// so forged tokens can't be used to hammer the provider
+const minKeyRefreshInterval = time.Minute
+
+// Provider talks to one OpenID Connect provider. Its configuration is
+// discovered on first use rather than at startup, so a provider outage
+// doesn't stop Chirpy from booting.
+type Provider struct {
+	config	Config
+	client	*http.Client
+
+	mu		sync.Mutex
+	metadata	*metadata
+	keys		map[string]*rsa.PublicKey
+	keysFetched	time.Time
+}
+
+func NewProvider(config Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{
+		config:	config,
+		client:	client,
+	}
+}
+
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	m := &metadata{}
+	err := p.getJSON(ctx, wellKnown, m)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering provider: %s", err)
+	}
+	// Stops a compromised or misconfigured document from pointing us at
+	// another issuer's tokens
+	if m.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("provider issuer %q doesn't match configured issuer %q", m.Issuer, p.config.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("provider configuration is missing endpoints")
+	}
+	p.metadata = m
+	return m, nil
+}
+
+// AuthCodeURL returns the provider URL to send the user to. codeChallenge is
+// the S256 PKCE challenge for the verifier later passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", "openid email")
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the validated ID token
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (IDToken, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return IDToken{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IDToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("error exchanging code: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1 << 20))
+	if err != nil {
+		return IDToken{}, fmt.Errorf("error reading token response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return IDToken{}, fmt.Errorf("token endpoint returned %s: %s", resp.Status, body)
+	}
+
+	tokens := struct {
+		IDToken	string	`json:"id_token"`
+	}{}
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("error decoding token response: %s", err)
+	}
+	if tokens.IDToken == "" {
+		return IDToken{}, fmt.Errorf("token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// flexibleBool accepts both true and "true", since some providers send
+// email_verified as a string
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce		string		`json:"nonce"`
+	AuthorizedParty	string		`json:"azp"`
+	Email		string		`json:"email"`
+	EmailVerified	flexibleBool	`json:"email_verified"`
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's
+// published keys, along with its issuer, audience, expiry and nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (IDToken, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return IDToken{}, err
+	}
+
+	claims := idTokenClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(m.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("invalid id token: %s", err)
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return IDToken{}, fmt.Errorf("id token was issued to another party")
+	}
+	if claims.Subject == "" {
+		return IDToken{}, fmt.Errorf("id token has no subject")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return IDToken{}, fmt.Errorf("id token nonce doesn't match")
+	}
+
+	return IDToken{
+		Issuer:		claims.Issuer,
+		Subject:	claims.Subject,
+		Email:		claims.Email,
+		EmailVerified:	bool(claims.EmailVerified),
+	}, nil
+}
+
+// key returns the provider's signing key with the given ID, refetching the
+// key set if it's unknown in case the provider has rotated keys
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < minKeyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+type jsonWebKey struct {
+	Kty	string	`json:"kty"`
+	Kid	string	`json:"kid"`
+	Use	string	`json:"use"`
+	N	string	`json:"n"`
+	E	string	`json:"e"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	keySet := struct {
+		Keys	[]jsonWebKey	`json:"keys"`
+	}{}
+	err := p.getJSON(ctx, p.metadata.JWKSURI, &keySet)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching signing keys: %s", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range keySet.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N:	new(big.Int).SetBytes(n),
+			E:	int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// getJSON is only called with p.mu held
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1 << 20)).Decode(v)
+}
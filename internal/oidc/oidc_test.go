@@ -0,0 +1,282 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeProvider is a minimal in-process OpenID Connect provider. Its token
+// endpoint returns whatever ID token claims the test sets.
+type fakeProvider struct {
+	server		*httptest.Server
+	key		*rsa.PrivateKey
+	kid		string
+	clientID	string
+	clientSecret	string
+	// Overrides applied to the claims of the next ID token issued
+	claims		jwt.MapClaims
+	signingKey	*rsa.PrivateKey
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeProvider{
+		key:		key,
+		kid:		"test-key",
+		clientID:	"chirpy",
+		clientSecret:	"s3cret",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":			f.server.URL,
+			"authorization_endpoint":	f.server.URL + "/authorize",
+			"token_endpoint":		f.server.URL + "/token",
+			"jwks_uri":			f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty":	"RSA",
+				"kid":	f.kid,
+				"use":	"sig",
+				"n":	base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":	base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != f.clientID || secret != f.clientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token":	"unused",
+			"token_type":	"Bearer",
+			"id_token":	f.idToken(t),
+		})
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeProvider) idToken(t *testing.T) string {
+	claims := jwt.MapClaims{
+		"iss":			f.server.URL,
+		"sub":			"user-123",
+		"aud":			f.clientID,
+		"iat":			time.Now().Unix(),
+		"exp":			time.Now().Add(5 * time.Minute).Unix(),
+		"nonce":		"the-nonce",
+		"email":		"saul@bettercall.com",
+		"email_verified":	true,
+	}
+	for k, v := range f.claims {
+		claims[k] = v
+	}
+
+	signingKey := f.key
+	if f.signingKey != nil {
+		signingKey = f.signingKey
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = f.kid
+	signed, err := token.SignedString(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (f *fakeProvider) provider() *Provider {
+	return NewProvider(Config{
+		Issuer:		f.server.URL,
+		ClientID:	f.clientID,
+		ClientSecret:	f.clientSecret,
+		RedirectURL:	"http://localhost:8080/api/login/oidc/test/callback",
+	}, f.server.Client())
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	f := newFakeProvider(t)
+
+	authURL, err := f.provider().AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-challenge")
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/authorize" {
+		t.Errorf("AuthCodeURL() path = %q, want /authorize", u.Path)
+	}
+	q := u.Query()
+	for param, want := range map[string]string{
+		"client_id":			f.clientID,
+		"state":			"the-state",
+		"nonce":			"the-nonce",
+		"code_challenge":		"the-challenge",
+		"code_challenge_method":	"S256",
+		"response_type":		"code",
+	} {
+		if got := q.Get(param); got != want {
+			t.Errorf("AuthCodeURL() %s = %q, want %q", param, got, want)
+		}
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	f := newFakeProvider(t)
+	p := NewProvider(Config{
+		Issuer:		f.server.URL + "/other",
+		ClientID:	f.clientID,
+	}, f.server.Client())
+
+	_, err := p.AuthCodeURL(context.Background(), "state", "nonce", "challenge")
+	if err == nil {
+		t.Error("AuthCodeURL() expected an error for a mismatched issuer")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name		string
+		code		string
+		nonce		string
+		claims		jwt.MapClaims
+		signingKey	*rsa.PrivateKey
+		want		IDToken
+		wantErr		bool
+	}{
+		{
+			name:	"Valid ID token",
+			code:	"good-code",
+			nonce:	"the-nonce",
+			want: IDToken{
+				Subject:	"user-123",
+				Email:		"saul@bettercall.com",
+				EmailVerified:	true,
+			},
+		},
+		{
+			name:	"email_verified sent as a string",
+			code:	"good-code",
+			nonce:	"the-nonce",
+			claims:	jwt.MapClaims{"email_verified": "true"},
+			want: IDToken{
+				Subject:	"user-123",
+				Email:		"saul@bettercall.com",
+				EmailVerified:	true,
+			},
+		},
+		{
+			name:	"Unverified email",
+			code:	"good-code",
+			nonce:	"the-nonce",
+			claims:	jwt.MapClaims{"email_verified": false},
+			want: IDToken{
+				Subject:	"user-123",
+				Email:		"saul@bettercall.com",
+				EmailVerified:	false,
+			},
+		},
+		{
+			name:		"Bad code",
+			code:		"bad-code",
+			nonce:		"the-nonce",
+			wantErr:	true,
+		},
+		{
+			name:		"Wrong nonce",
+			code:		"good-code",
+			nonce:		"another-nonce",
+			wantErr:	true,
+		},
+		{
+			name:		"Wrong audience",
+			code:		"good-code",
+			nonce:		"the-nonce",
+			claims:		jwt.MapClaims{"aud": "someone-else"},
+			wantErr:	true,
+		},
+		{
+			name:		"Multiple audiences without azp",
+			code:		"good-code",
+			nonce:		"the-nonce",
+			claims:		jwt.MapClaims{"aud": []string{"chirpy", "someone-else"}},
+			wantErr:	true,
+		},
+		{
+			name:		"Wrong issuer",
+			code:		"good-code",
+			nonce:		"the-nonce",
+			claims:		jwt.MapClaims{"iss": "https://evil.example.com"},
+			wantErr:	true,
+		},
+		{
+			name:		"Expired",
+			code:		"good-code",
+			nonce:		"the-nonce",
+			claims:		jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()},
+			wantErr:	true,
+		},
+		{
+			name:		"Signed by another key",
+			code:		"good-code",
+			nonce:		"the-nonce",
+			signingKey:	otherKey,
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeProvider(t)
+			f.claims = tt.claims
+			f.signingKey = tt.signingKey
+
+			got, err := f.provider().Exchange(context.Background(), tt.code, "the-verifier", tt.nonce)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exchange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			tt.want.Issuer = f.server.URL
+			if got != tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
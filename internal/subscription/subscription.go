@@ -0,0 +1,94 @@
+// Package subscription holds the Chirpy Red subscription state machine.
+package subscription
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultPeriod is used when a billing event doesn't say when the period ends
+const DefaultPeriod = 30 * 24 * time.Hour
+
+type Status string
+
+const (
+	// StatusNone is a user who has never subscribed
+	StatusNone	Status = ""
+	StatusActive	Status = "active"
+	// StatusPastDue keeps the benefits while Polka retries a failed payment
+	StatusPastDue	Status = "past_due"
+	// StatusCancelled keeps the benefits until the paid period ends
+	StatusCancelled	Status = "cancelled"
+	StatusExpired	Status = "expired"
+)
+
+type Event string
+
+const (
+	EventUpgraded		Event = "user.upgraded"
+	EventDowngraded		Event = "user.downgraded"
+	EventRenewed		Event = "subscription.renewed"
+	EventCancelled		Event = "subscription.cancelled"
+	EventPaymentFailed	Event = "subscription.payment_failed"
+	// EventPeriodEnded isn't sent by Polka, it's raised by the expiry job
+	EventPeriodEnded	Event = "period_ended"
+)
+
+var ErrInvalidTransition = errors.New("invalid subscription transition")
+
+// transitions lists, for each event, the statuses it can be applied in and
+// the status it leads to
+var transitions = map[Event]struct {
+	from	[]Status
+	to	Status
+}{
+	EventUpgraded:		{[]Status{StatusNone, StatusExpired, StatusCancelled, StatusPastDue}, StatusActive},
+	EventRenewed:		{[]Status{StatusActive, StatusPastDue, StatusCancelled}, StatusActive},
+	EventCancelled:		{[]Status{StatusActive, StatusPastDue}, StatusCancelled},
+	EventPaymentFailed:	{[]Status{StatusActive}, StatusPastDue},
+	EventDowngraded:	{[]Status{StatusActive, StatusPastDue, StatusCancelled}, StatusExpired},
+	EventPeriodEnded:	{[]Status{StatusActive, StatusPastDue, StatusCancelled}, StatusExpired},
+}
+
+// IsWebhookEvent reports whether event is one Polka sends about subscriptions
+func IsWebhookEvent(event string) bool {
+	_, ok := transitions[Event(event)]
+	return ok && Event(event) != EventPeriodEnded
+}
+
+// Transition returns the status a subscription moves to when event happens
+// in status from
+func Transition(from Status, event Event) (Status, error) {
+	t, ok := transitions[event]
+	if !ok {
+		return from, fmt.Errorf("%w: unknown event %q", ErrInvalidTransition, event)
+	}
+	for _, s := range t.from {
+		if s == from {
+			return t.to, nil
+		}
+	}
+	return from, fmt.Errorf("%w: %q in status %q", ErrInvalidTransition, event, from)
+}
+
+// Entitled reports whether a subscription in status gets Chirpy Red
+func (s Status) Entitled() bool {
+	return s == StatusActive || s == StatusPastDue || s == StatusCancelled
+}
+
+// NextPeriod works out the period started by an upgrade or renewal. A
+// renewal continues from the end of the current period, unless that's
+// already passed. Explicit start and end times from the event win.
+func NextPeriod(event Event, currentEnd, start, end, now time.Time) (time.Time, time.Time) {
+	if start.IsZero() {
+		start = now
+		if event == EventRenewed && currentEnd.After(now) {
+			start = currentEnd
+		}
+	}
+	if end.IsZero() || !end.After(start) {
+		end = start.Add(DefaultPeriod)
+	}
+	return start, end
+}
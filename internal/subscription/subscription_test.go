@@ -0,0 +1,151 @@
+package subscription
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		name		string
+		from		Status
+		event		Event
+		want		Status
+		wantErr		bool
+	}{
+		{
+			name:	"First upgrade",
+			from:	StatusNone,
+			event:	EventUpgraded,
+			want:	StatusActive,
+		},
+		{
+			name:	"Upgrade after expiry",
+			from:	StatusExpired,
+			event:	EventUpgraded,
+			want:	StatusActive,
+		},
+		{
+			name:	"Renewal clears a failed payment",
+			from:	StatusPastDue,
+			event:	EventRenewed,
+			want:	StatusActive,
+		},
+		{
+			name:	"Payment failure",
+			from:	StatusActive,
+			event:	EventPaymentFailed,
+			want:	StatusPastDue,
+		},
+		{
+			name:	"Cancel",
+			from:	StatusActive,
+			event:	EventCancelled,
+			want:	StatusCancelled,
+		},
+		{
+			name:	"Downgrade",
+			from:	StatusCancelled,
+			event:	EventDowngraded,
+			want:	StatusExpired,
+		},
+		{
+			name:	"Period ends",
+			from:	StatusPastDue,
+			event:	EventPeriodEnded,
+			want:	StatusExpired,
+		},
+		{
+			name:		"Renewing an expired subscription",
+			from:		StatusExpired,
+			event:		EventRenewed,
+			wantErr:	true,
+		},
+		{
+			name:		"Cancelling without a subscription",
+			from:		StatusNone,
+			event:		EventCancelled,
+			wantErr:	true,
+		},
+		{
+			name:		"Unknown event",
+			from:		StatusActive,
+			event:		"user.exploded",
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Transition(tt.from, tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Transition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTransition) {
+					t.Errorf("Transition() error = %v, want ErrInvalidTransition", err)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Transition() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextPeriod(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	currentEnd := now.Add(5 * 24 * time.Hour)
+
+	tests := []struct {
+		name		string
+		event		Event
+		currentEnd	time.Time
+		start		time.Time
+		end		time.Time
+		wantStart	time.Time
+		wantEnd		time.Time
+	}{
+		{
+			name:		"Upgrade starts now",
+			event:		EventUpgraded,
+			currentEnd:	currentEnd,
+			wantStart:	now,
+			wantEnd:	now.Add(DefaultPeriod),
+		},
+		{
+			name:		"Early renewal continues the current period",
+			event:		EventRenewed,
+			currentEnd:	currentEnd,
+			wantStart:	currentEnd,
+			wantEnd:	currentEnd.Add(DefaultPeriod),
+		},
+		{
+			name:		"Late renewal starts now",
+			event:		EventRenewed,
+			currentEnd:	now.Add(-time.Hour),
+			wantStart:	now,
+			wantEnd:	now.Add(DefaultPeriod),
+		},
+		{
+			name:		"Explicit period",
+			event:		EventRenewed,
+			currentEnd:	currentEnd,
+			start:		now,
+			end:		now.Add(365 * 24 * time.Hour),
+			wantStart:	now,
+			wantEnd:	now.Add(365 * 24 * time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := NextPeriod(tt.event, tt.currentEnd, tt.start, tt.end, now)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("NextPeriod() = %v, %v, want %v, %v", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateAddress = errors.New("webhook address isn't public")
+
+// Ranges that aren't covered by the netip.Addr predicates but still aren't
+// somewhere a webhook should go
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	// Carrier-grade NAT
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	// Benchmarking
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// NAT64, which can reach IPv4 addresses behind it
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// PublicAddress reports whether ip is a public unicast address, so not
+// loopback, private, link-local (like the 169.254.169.254 metadata service),
+// multicast or otherwise reserved
+func PublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialControl runs after DNS resolution, just before each connection is
+// made, so a hostname can't pass a check and then resolve somewhere private
+func dialControl(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !PublicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// NewClient returns the client deliveries are sent with. Redirects aren't
+// followed and, unless allowPrivate is set for local development, only
+// public addresses can be connected to.
+func NewClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:	5 * time.Second,
+		KeepAlive:	30 * time.Second,
+	}
+	if !allowPrivate {
+		dialer.Control = dialControl
+	}
+	return &http.Client{
+		Timeout:	10 * time.Second,
+		Transport:	&http.Transport{
+			// No proxy, since the check would apply to the proxy's address
+			// instead of the endpoint's
+			Proxy:			nil,
+			DialContext:		dialer.DialContext,
+			TLSHandshakeTimeout:	5 * time.Second,
+			MaxIdleConns:		100,
+			IdleConnTimeout:	90 * time.Second,
+		},
+		// A redirect could point the signed payload somewhere the owner never
+		// registered, so it counts as a failed delivery instead
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
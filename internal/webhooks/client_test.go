@@ -0,0 +1,61 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip	string
+		want	bool
+	}{
+		{ip: "93.184.216.34", want: true},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{ip: "127.0.0.1", want: false},
+		{ip: "::1", want: false},
+		{ip: "10.1.2.3", want: false},
+		{ip: "172.16.0.1", want: false},
+		{ip: "192.168.1.1", want: false},
+		{ip: "169.254.169.254", want: false},
+		{ip: "100.64.0.1", want: false},
+		{ip: "0.0.0.0", want: false},
+		{ip: "224.0.0.1", want: false},
+		{ip: "fd00::1", want: false},
+		{ip: "fe80::1", want: false},
+		{ip: "::ffff:127.0.0.1", want: false},
+		{ip: "64:ff9b::a9fe:a9fe", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got := PublicAddress(netip.MustParseAddr(tt.ip))
+			if got != tt.want {
+				t.Errorf("PublicAddress(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	_, err := Send(context.Background(), NewClient(false), server.URL, "s3cret", uuid.New(), []byte(`{}`))
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Send() to %s error = %v, want ErrPrivateAddress", server.URL, err)
+	}
+
+	status, err := Send(context.Background(), NewClient(true), server.URL, "s3cret", uuid.New(), []byte(`{}`))
+	if err != nil || status != http.StatusOK {
+		t.Errorf("Send() with private addresses allowed = %d, %v, want 200", status, err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package webhooks sends signed event notifications to endpoints registered
+// by integrators.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/auth"
+)
+
+const (
+	EventChirpCreated	= "chirp.created"
+	EventChirpDeleted	= "chirp.deleted"
+	EventUserUpgraded	= "user.upgraded"
+	// Chirpy has no follows yet, so nothing sends this, but endpoints can
+	// subscribe ahead of time
+	EventFollowCreated	= "follow.created"
+)
+
+var EventTypes = []string{EventChirpCreated, EventChirpDeleted, EventUserUpgraded, EventFollowCreated}
+
+func ValidEventType(eventType string) bool {
+	return slices.Contains(EventTypes, eventType)
+}
+
+const (
+	SignatureHeader	= "Chirpy-Signature"
+	EventIDHeader	= "Chirpy-Event-Id"
+	// Deliveries still failing after this many attempts are dead-lettered
+	MaxAttempts	= 10
+	baseBackoff	= 30 * time.Second
+	maxBackoff	= 12 * time.Hour
+)
+
+// Event is the JSON body sent to endpoints
+type Event struct {
+	ID		uuid.UUID	`json:"id"`
+	Type		string		`json:"type"`
+	CreatedAt	time.Time	`json:"created_at"`
+	Data		any		`json:"data"`
+}
+
+// Backoff returns how long to wait before retrying after the given number of
+// failed attempts, doubling each time up to a cap
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := baseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+// Send posts a payload signed with the endpoint's secret. Receivers verify the
+// Chirpy-Signature header the same way Chirpy verifies Polka's. Any response
+// other than a 2xx is an error, returned along with the status code.
+func Send(ctx context.Context, client *http.Client, url, secret string, eventID uuid.UUID, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(EventIDHeader, eventID.String())
+	req.Header.Set(SignatureHeader, auth.WebhookSignatureHeader(secret, time.Now(), payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64 << 10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,82 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/auth"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts	int
+		want		time.Duration
+	}{
+		{attempts: 0, want: 30 * time.Second},
+		{attempts: 1, want: 30 * time.Second},
+		{attempts: 2, want: time.Minute},
+		{attempts: 5, want: 8 * time.Minute},
+		{attempts: 20, want: 12 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	payload := []byte(`{"type":"chirp.created"}`)
+	eventID := uuid.New()
+
+	tests := []struct {
+		name		string
+		status		int
+		wantErr		bool
+	}{
+		{
+			name:		"Accepted",
+			status:		http.StatusOK,
+			wantErr:	false,
+		},
+		{
+			name:		"Server error",
+			status:		http.StatusInternalServerError,
+			wantErr:	true,
+		},
+		{
+			name:		"Redirects aren't followed as success",
+			status:		http.StatusNotModified,
+			wantErr:	true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				err := auth.VerifyWebhookSignature([]string{"s3cret"}, r.Header.Get(SignatureHeader), body, time.Minute, time.Now())
+				if err != nil || r.Header.Get(EventIDHeader) != eventID.String() {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			status, err := Send(context.Background(), server.Client(), server.URL, "s3cret", eventID, payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.status {
+				t.Errorf("Send() status = %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
@@ -2,14 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"log/slog"
 	"net/http"
-	"log"
 )
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		slog.Error("Error marshalling JSON", "error", err)
 		w.WriteHeader(500)
 		return
 	}
@@ -18,19 +18,23 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
+// respondWithError logs err and responds with msg. The request ID set by
+// middlewareRequestLogging is included so a user's report can be matched
+// to the logs.
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) { 
-	if err != nil { 
-		log.Println(err)
-	}
+	requestID := w.Header().Get(requestIDHeader)
 	if code > 499 { 
-		log.Println("Responding with 5xx error: %s", msg)
+		slog.Error("Responding with 5xx error", "request_id", requestID, "status", code, "response", msg, "error", err)
+	} else if err != nil { 
+		slog.Info("Responding with error", "request_id", requestID, "status", code, "response", msg, "error", err)
 	}
 	
 	type errorResponse struct {
-		Error string `json:"error"`
+		Error		string	`json:"error"`
+		RequestID	string	`json:"request_id,omitempty"`
 	}
 	respondWithJSON(w, code, errorResponse{
-		Error: msg,
+		Error:		msg,
+		RequestID:	requestID,
 	})
 }
-
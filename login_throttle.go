@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// clientIP is the address of the connecting peer. Forwarding headers are
+// ignored since any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func accountThrottleKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginAttempt counts an attempt against the per-account and per-IP
+// throttles in the same step as checking them, so concurrent guesses can't
+// all get through the free attempts while the first is still hashing. It
+// returns how long to wait if either is blocking, in which case nothing is
+// counted. Call loginSucceeded once the attempt turns out to be valid.
+func (cfg *apiConfig) loginAttempt(r *http.Request, accountKey string) time.Duration {
+	return cfg.throttleAttempt(accountKey, clientIP(r))
+}
+
+func (cfg *apiConfig) throttleAttempt(accountKey, ipKey string) time.Duration {
+	wait := cfg.accountThrottle.Attempt(accountKey)
+	if wait > 0 {
+		return wait
+	}
+	wait = cfg.ipThrottle.Attempt(ipKey)
+	if wait > 0 {
+		cfg.accountThrottle.Refund(accountKey)
+	}
+	return wait
+}
+
+// loginAllowed is loginAttempt for JSON endpoints, responding with 429 and a
+// Retry-After header if either throttle is blocking.
+func (cfg *apiConfig) loginAllowed(w http.ResponseWriter, r *http.Request, accountKey string) bool {
+	wait := cfg.loginAttempt(r, accountKey)
+	if wait == 0 {
+		return true
+	}
+	respondTooManyRequests(w, wait, "Too many login attempts, try again later")
+	return false
+}
+
+// emailRequestAllowed throttles endpoints that email an address the caller
+// names, so they can't be used to flood someone's inbox. Every request counts
+// as an attempt, since there's no success to reset on. The keys are separate
+// from the login ones, so asking for emails can't lock anyone out of logging in.
+func (cfg *apiConfig) emailRequestAllowed(w http.ResponseWriter, r *http.Request, email string) bool {
+	wait := cfg.throttleAttempt("email:" + accountThrottleKey(email), "email:" + clientIP(r))
+	if wait == 0 {
+		return true
+	}
+	respondTooManyRequests(w, wait, "Too many requests, try again later")
+	return false
+}
+
+func respondTooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, msg, nil)
+}
+
+func (cfg *apiConfig) loginSucceeded(r *http.Request, accountKey string) {
+	cfg.accountThrottle.Success(accountKey)
+	// The IP only gets this attempt back, so one valid account doesn't let an
+	// attacker keep guessing others from the same address
+	cfg.ipThrottle.Refund(clientIP(r))
+}
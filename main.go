@@ -1,14 +1,31 @@
 package main
 
 import ( 
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
-	"log"
+	"log/slog"
+	"sync"
 	"sync/atomic"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 	"database/sql"
 
 	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/auth"
+	"github.com/pjjimiso/chirpy/internal/config"
+	"github.com/pjjimiso/chirpy/internal/entitlements"
+	"github.com/pjjimiso/chirpy/internal/events"
+	"github.com/pjjimiso/chirpy/internal/health"
+	"github.com/pjjimiso/chirpy/internal/mailer"
+	"github.com/pjjimiso/chirpy/internal/oidc"
+	"github.com/pjjimiso/chirpy/internal/webhooks"
+	"github.com/alexedwards/argon2id"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -19,25 +36,107 @@ type apiConfig struct {
 	platform	string
 	jwtSecret	string
 	polkaApiKey	string
+	polkaWebhookSecrets	[]string
+	denylist	*auth.Denylist
+	mailer		mailer.Mailer
+	baseURL		string
+	accountThrottle	*auth.LoginThrottle
+	ipThrottle	*auth.LoginThrottle
+	passwordPolicy	auth.PasswordPolicy
+	passwordParams	*argon2id.Params
+	dbConn		*sql.DB
+	deletionGracePeriod	time.Duration
+	oidcProviders	map[string]*oidc.Provider
+	adminApiKey	string
+	entitlements	*entitlements.Service
+	eventBus	events.EventBus
+	outboxWake	chan struct{}
+	webhookClient	*http.Client
+	background	sync.WaitGroup
+	draining	atomic.Bool
+	health		*health.Registry
+	hashSlots	chan struct{}
 }
 
 func main() {
-	const filepathRoot = "."
-	const port = "8080"
+	// Loaded first, since it can set CONFIG_FILE
+	godotenv.Load()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	printConfig := flag.Bool("print-config", false, "print the loaded config, with secrets redacted, and exit")
+	flag.Parse()
 
+	logLevel := new(slog.LevelVar)
+	slog.SetDefault(newLogger(os.Stderr, logLevel))
 
-	godotenv.Load()
-	plat :=		os.Getenv("PLATFORM")
-	secret :=	os.Getenv("JWT_SECRET")
-	polkaApiKey :=	os.Getenv("POLKA_KEY")
-	dbURL :=	os.Getenv("DB_URL")
-	if dbURL == "" {
-		log.Fatal("DB_URL must be set")
+	conf, err := config.Load(*configPath, os.LookupEnv)
+	if err != nil {
+		fatal("Error loading config", "error", err)
+	}
+	validationErr := conf.Validate()
+	if *printConfig {
+		fmt.Print(conf.Redacted())
+		if validationErr != nil {
+			fmt.Fprintf(os.Stderr, "Invalid config:\n%s\n", validationErr)
+			os.Exit(1)
+		}
+		return
+	}
+	if validationErr != nil {
+		fatal("Invalid config", "error", validationErr)
+	}
+	logLevel.Set(conf.LogLevel)
+
+	passwordPolicy := auth.DefaultPasswordPolicy
+	passwordPolicy.MinLength = conf.PasswordMinLength
+	passwordPolicy.MaxLength = conf.PasswordMaxLength
+	passwordPolicy.MinEntropyBits = conf.PasswordMinEntropyBits
+	passwordParams, err := auth.NewPasswordParams(conf.Argon2MemoryKiB, conf.Argon2Iterations, conf.Argon2Parallelism)
+	if err != nil {
+		fatal("Invalid argon2 parameters", "error", err)
+	}
+	if conf.BreachedPasswordsDir != "" {
+		breached, err := auth.OpenBreachedPasswordList(conf.BreachedPasswordsDir)
+		if err != nil {
+			fatal("Error opening breached password list", "error", err)
+		}
+		passwordPolicy.Breached = breached
+	}
+
+	// Without an SMTP relay, mail is written to a file (or the log) instead.
+	// Only dev logs the bodies, since they hold live tokens.
+	var mail mailer.Mailer = &mailer.LogMailer{Path: conf.MailLogPath, ShowBody: conf.Platform == "dev"}
+	if conf.SMTPAddr != "" {
+		mail = mailer.SMTPMailer{
+			Addr:		conf.SMTPAddr,
+			Username:	conf.SMTPUsername,
+			Password:	conf.SMTPPassword,
+			From:		conf.MailFrom,
+		}
+	}
+
+	oidcProviders := map[string]*oidc.Provider{}
+	for _, p := range conf.OIDCProviders {
+		oidcProviders[p.Name] = oidc.NewProvider(oidc.Config{
+			Issuer:		p.Issuer,
+			ClientID:	p.ClientID,
+			ClientSecret:	p.ClientSecret,
+			RedirectURL:	conf.BaseURL + "/api/login/oidc/" + p.Name + "/callback",
+		}, nil)
+	}
+
+	// Plan limits can be changed without a deploy by pointing
+	// ENTITLEMENTS_FILE at a JSON file of plan definitions
+	plans := entitlements.DefaultPlans
+	if conf.EntitlementsFile != "" {
+		plans, err = entitlements.LoadPlans(conf.EntitlementsFile)
+		if err != nil {
+			fatal("Invalid ENTITLEMENTS_FILE", "error", err)
+		}
 	}
 
-	db, err := sql.Open("postgres", dbURL)
+	db, err := sql.Open("postgres", conf.DBURL)
 	if err != nil {
-		log.Fatal("Error opening database: %s", err)
+		fatal("Error opening database", "error", err)
 	}
 
 	dbQueries := database.New(db)
@@ -45,45 +144,146 @@ func main() {
 	apiCfg := apiConfig{
 		fileserverHits:	atomic.Int32{},
 		db:		dbQueries,
-		platform:	plat,
-		jwtSecret:	secret,
-		polkaApiKey:	polkaApiKey,
+		platform:	conf.Platform,
+		jwtSecret:	conf.JWTSecret,
+		polkaApiKey:	conf.PolkaKey,
+		polkaWebhookSecrets:	conf.PolkaWebhookSecrets,
+		denylist:	auth.NewDenylist(denylistStore{db: dbQueries}),
+		mailer:		mail,
+		baseURL:	conf.BaseURL,
+		accountThrottle:	auth.NewLoginThrottle(auth.DefaultAccountThrottlePolicy),
+		ipThrottle:	auth.NewLoginThrottle(auth.DefaultIPThrottlePolicy),
+		passwordPolicy:	passwordPolicy,
+		passwordParams:	passwordParams,
+		hashSlots:	make(chan struct{}, conf.Argon2MaxConcurrency),
+		oidcProviders:	oidcProviders,
+		dbConn:		db,
+		deletionGracePeriod:	conf.AccountDeletionGracePeriod,
+		adminApiKey:	conf.AdminAPIKey,
+		outboxWake:	make(chan struct{}, 1),
+		// Endpoints on localhost are only reachable in development
+		webhookClient:	webhooks.NewClient(conf.Platform == "dev"),
+		health:		health.NewRegistry(readinessCheckTimeout),
+	}
+	apiCfg.entitlements = entitlements.New(plans, apiCfg.userPlanName)
+	err = apiCfg.registerHealthChecks()
+	if err != nil {
+		fatal("Error registering health checks", "error", err)
 	}
 
+	bus := events.NewMemoryBus()
+	bus.Subscribe(events.AllEvents, apiCfg.enqueueWebhookDeliveries)
+	apiCfg.eventBus = bus
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	apiCfg.goBackground(func() { apiCfg.runAccountDeletion(workersCtx, 10 * time.Minute) })
+	apiCfg.goBackground(func() { apiCfg.runSubscriptionExpiry(workersCtx, 10 * time.Minute) })
+	apiCfg.goBackground(func() { apiCfg.runOutboxRelay(workersCtx, 2 * time.Second) })
+	apiCfg.goBackground(func() { apiCfg.runWebhookDelivery(workersCtx, 5 * time.Second) })
+	apiCfg.goBackground(func() { apiCfg.runDenylistCleanup(workersCtx, time.Hour) })
+
 	mux := http.NewServeMux()
-	fsHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	fsHandler := http.StripPrefix("/app", http.FileServer(http.Dir(conf.FilepathRoot)))
 	mux.Handle("/app/", apiCfg.middlewareMetricsInc(fsHandler))
 
-	mux.HandleFunc("GET /api/healthz", handlerReadyCheck)
+	mux.HandleFunc("GET /api/livez", handlerLiveCheck)
+	mux.HandleFunc("GET /api/readyz", apiCfg.handlerReadyCheck)
+	// Kept for probes configured before livez and readyz existed, with its
+	// original plain-text liveness response
+	mux.HandleFunc("GET /api/healthz", handlerLiveCheck)
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdateCredentials)
+	mux.HandleFunc("GET /api/users/verify-email", apiCfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify-email/resend", apiCfg.handlerResendVerification)
+	mux.HandleFunc("POST /api/users/2fa", apiCfg.handlerTwoFactorEnroll)
+	mux.HandleFunc("POST /api/users/2fa/confirm", apiCfg.handlerTwoFactorConfirm)
+	mux.HandleFunc("DELETE /api/users/me", apiCfg.handlerUsersDelete)
+	mux.HandleFunc("POST /api/users/me/export", apiCfg.handlerDataExportCreate)
+	mux.HandleFunc("GET /api/users/me/export/{exportID}", apiCfg.handlerDataExportGet)
+	mux.HandleFunc("POST /api/users/me/tokens", apiCfg.handlerAPITokensCreate)
+	mux.HandleFunc("GET /api/users/me/tokens", apiCfg.handlerAPITokensList)
+	mux.HandleFunc("DELETE /api/users/me/tokens/{tokenID}", apiCfg.handlerAPITokensRevoke)
+	mux.HandleFunc("GET /api/users/me/entitlements", apiCfg.handlerEntitlementsGet)
+	mux.HandleFunc("POST /api/users/me/webhooks", apiCfg.handlerWebhookEndpointsCreate)
+	mux.HandleFunc("GET /api/users/me/webhooks", apiCfg.handlerWebhookEndpointsList)
+	mux.HandleFunc("DELETE /api/users/me/webhooks/{endpointID}", apiCfg.handlerWebhookEndpointsDelete)
+	mux.HandleFunc("GET /api/users/me/webhooks/{endpointID}/deliveries", apiCfg.handlerWebhookDeliveriesList)
+	mux.HandleFunc("GET /api/users/me/webhooks/{endpointID}/deliveries/{deliveryID}", apiCfg.handlerWebhookDeliveriesGet)
+	mux.HandleFunc("POST /api/users/me/webhooks/{endpointID}/deliveries/{deliveryID}/redeliver", apiCfg.handlerWebhookDeliveriesRedeliver)
 
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsGetAll)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGet)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.handlerChirpsUpdate)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
 
 	mux.HandleFunc("POST /api/login", apiCfg.handlerUsersLogin)
+	mux.HandleFunc("POST /api/login/magic-link", apiCfg.handlerMagicLinkRequest)
+	mux.HandleFunc("GET /api/login/magic-link/verify", apiCfg.handlerMagicLinkVerify)
+	mux.HandleFunc("GET /api/login/oidc/{provider}", apiCfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/login/oidc/{provider}/callback", apiCfg.handlerOIDCCallback)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefreshAccessToken)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevokeAccessToken)
+	mux.HandleFunc("POST /api/password-reset/request", apiCfg.handlerPasswordResetRequest)
+	mux.HandleFunc("POST /api/password-reset/confirm", apiCfg.handlerPasswordResetConfirm)
+
+	mux.HandleFunc("POST /oauth/clients", apiCfg.handlerOAuthClientsCreate)
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.handlerOAuthAuthorizeDecision)
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.handlerOAuthIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhooks)
 
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
 	mux.HandleFunc("POST /admin/reset", apiCfg.handlerAdminReset)
+	mux.HandleFunc("GET /admin/webhooks/events", apiCfg.handlerWebhookEventsList)
+	mux.HandleFunc("POST /admin/webhooks/events/{eventID}/replay", apiCfg.handlerWebhookEventsReplay)
+	mux.HandleFunc("POST /admin/webhooks/endpoints", apiCfg.handlerWebhookEndpointsCreate)
+	mux.HandleFunc("GET /admin/webhooks/endpoints", apiCfg.handlerWebhookEndpointsList)
+	mux.HandleFunc("DELETE /admin/webhooks/endpoints/{endpointID}", apiCfg.handlerWebhookEndpointsDelete)
+	mux.HandleFunc("GET /admin/webhooks/endpoints/{endpointID}/deliveries", apiCfg.handlerWebhookDeliveriesList)
+	mux.HandleFunc("GET /admin/webhooks/endpoints/{endpointID}/deliveries/{deliveryID}", apiCfg.handlerWebhookDeliveriesGet)
+	mux.HandleFunc("POST /admin/webhooks/endpoints/{endpointID}/deliveries/{deliveryID}/redeliver", apiCfg.handlerWebhookDeliveriesRedeliver)
 
 
-
-	srv := http.Server {
-		Addr:		":" + port,
-		Handler:	mux,
+	srv := &http.Server {
+		Addr:			":" + strconv.Itoa(conf.Port),
+		Handler:		middlewareRequestLogging(mux),
+		ErrorLog:		slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+		ReadHeaderTimeout:	conf.HTTPReadHeaderTimeout,
+		ReadTimeout:		conf.HTTPReadTimeout,
+		WriteTimeout:		conf.HTTPWriteTimeout,
+		IdleTimeout:		conf.HTTPIdleTimeout,
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
 		// Error starting or closing listener
-		fmt.Errorf("HTTP server ListenAndServe: %v", err)
-		log.Fatalf("HTTP server ListenAndServe: %v", err)
+		fatal("HTTP server ListenAndServe", "error", err)
+	case <-signalCtx.Done():
 	}
-}
+	// A second signal kills the process without waiting
+	stopSignals()
+	slog.Info("Shutting down")
 
+	err = apiCfg.shutdown(srv, stopWorkers, db, conf.ShutdownDrainDelay, conf.ShutdownTimeout)
+	if err != nil {
+		fatal("Error shutting down", "error", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		fatal("HTTP server ListenAndServe", "error", err)
+	}
+	slog.Info("Shut down cleanly")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/events"
+)
+
+const (
+	outboxBatch		= 100
+	outboxLease		= time.Minute
+	outboxMaxRetryDelay	= 5 * time.Minute
+	// Published events are kept for a while to help with debugging
+	outboxRetention		= 7 * 24 * time.Hour
+)
+
+// recordEvent writes a domain event to the outbox. Called with a
+// transaction's queries, the event is only published if the change it
+// describes commits.
+func recordEvent(ctx context.Context, q *database.Queries, eventType string, userID uuid.UUID, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return q.CreateOutboxEvent(ctx, database.CreateOutboxEventParams{
+		EventType:	eventType,
+		UserID:		uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		Payload:	string(payload),
+	})
+}
+
+// wakeOutboxRelay tells the relay there's something to publish, so events
+// don't wait for the next poll
+func (cfg *apiConfig) wakeOutboxRelay() {
+	select {
+	case cfg.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// runOutboxRelay publishes outbox events to the event bus, polling every
+// interval or when woken, until ctx is cancelled. Events are marked published only after the bus
+// accepts them, so they're delivered at least once.
+func (cfg *apiConfig) runOutboxRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+	for {
+		cfg.relayOutbox(ctx)
+		if time.Since(lastCleanup) > time.Hour {
+			cfg.cleanUpOutbox()
+			lastCleanup = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.outboxWake:
+		}
+	}
+}
+
+// relayOutbox publishes claimed events one at a time until none are due or ctx
+// is cancelled. Events claimed but not reached before shutdown stay leased
+// and are picked up again once the lease runs out.
+func (cfg *apiConfig) relayOutbox(ctx context.Context) {
+	for ctx.Err() == nil {
+		claimCtx, cancel := context.WithTimeout(ctx, 10 * time.Second)
+		claimed, err := cfg.db.ClaimOutboxEvents(claimCtx, database.ClaimOutboxEventsParams{
+			LeaseUntil:	time.Now().Add(outboxLease),
+			MaxResults:	outboxBatch,
+		})
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Error claiming outbox events", "error", err)
+			}
+			return
+		}
+		for _, row := range claimed {
+			if ctx.Err() != nil {
+				return
+			}
+			cfg.publishOutboxEvent(row)
+		}
+		if len(claimed) < outboxBatch {
+			return
+		}
+	}
+}
+
+func (cfg *apiConfig) publishOutboxEvent(row database.Outbox) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+	defer cancel()
+
+	publishErr := cfg.eventBus.Publish(ctx, events.Event{
+		ID:		row.ID,
+		Type:		row.EventType,
+		UserID:		row.UserID.UUID,
+		CreatedAt:	row.CreatedAt,
+		Payload:	json.RawMessage(row.Payload),
+	})
+	if publishErr == nil {
+		err := cfg.db.MarkOutboxEventPublished(ctx, row.ID)
+		if err != nil {
+			slog.Error("Error marking outbox event published", "event_id", row.ID, "error", err)
+		}
+		return
+	}
+
+	slog.Warn("Error publishing outbox event", "event_id", row.ID, "event_type", row.EventType, "error", publishErr)
+	delay := min(time.Second << min(row.Attempts, 16), outboxMaxRetryDelay)
+	err := cfg.db.RetryOutboxEvent(ctx, database.RetryOutboxEventParams{
+		ID:		row.ID,
+		LastError:	sql.NullString{String: publishErr.Error(), Valid: true},
+		NextAttemptAt:	time.Now().Add(delay),
+	})
+	if err != nil {
+		slog.Error("Error rescheduling outbox event", "event_id", row.ID, "error", err)
+	}
+}
+
+func (cfg *apiConfig) cleanUpOutbox() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := cfg.db.DeletePublishedOutboxEvents(ctx, sql.NullTime{Time: time.Now().Add(-outboxRetention), Valid: true})
+	if err != nil {
+		slog.Error("Error cleaning up outbox", "error", err)
+	}
+}
@@ -1,10 +1,58 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"embed"
+	"errors"
+	"net/http"
+	"time"
 
-func handlerReadyCheck(w http.ResponseWriter, r *http.Request) {
+	"github.com/pjjimiso/chirpy/internal/health"
+)
+
+const readinessCheckTimeout = 2 * time.Second
+
+// The migrations are embedded so readiness knows which version this build
+// expects, wherever it's deployed
+//go:embed sql/schema/*.sql
+var migrations embed.FS
+
+// registerHealthChecks sets up the checks behind /api/readyz. Anything else
+// Chirpy comes to depend on should register a check here.
+func (cfg *apiConfig) registerHealthChecks() error {
+	expected, err := health.LatestMigration(migrations, "sql/schema")
+	if err != nil {
+		return err
+	}
+	cfg.health.Register("server", cfg.checkNotDraining)
+	cfg.health.Register("database", health.PingDB(cfg.dbConn))
+	cfg.health.Register("migrations", health.MigrationVersion(cfg.dbConn, expected))
+	return nil
+}
+
+// checkNotDraining fails once shutdown has started, so load balancers stop
+// routing new requests here while in-flight ones finish
+func (cfg *apiConfig) checkNotDraining(ctx context.Context) error {
+	if cfg.draining.Load() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+// handlerLiveCheck only reports that the process is serving requests. It
+// doesn't look at dependencies, so an outage doesn't get the server restarted.
+func handlerLiveCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(http.StatusText(http.StatusOK)))
 }
 
+func (cfg *apiConfig) handlerReadyCheck(w http.ResponseWriter, r *http.Request) {
+	report := cfg.health.Run(r.Context())
+	code := http.StatusOK
+	if report.Status != health.StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, report)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	scopeChirpsRead		= "chirps:read"
+	scopeChirpsWrite	= "chirps:write"
+	scopeProfileWrite	= "profile:write"
+	// Account security settings are off limits to third parties, so this
+	// scope is never granted to OAuth clients and only first-party tokens
+	// carry it
+	scopeAccount		= "account"
+)
+
+// oauthScopes are the scopes an OAuth client or personal API token can be
+// granted, with the description shown to the user on the consent page
+var oauthScopes = map[string]string{
+	scopeChirpsRead:	"Read chirps",
+	scopeChirpsWrite:	"Post, edit and delete chirps as you",
+	scopeProfileWrite:	"Change your email address and password",
+}
+
+// parseScope splits a space separated scope string, rejecting anything that
+// can't be delegated to an OAuth client or API token
+func parseScope(scope string) ([]string, error) {
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if _, ok := oauthScopes[s]; !ok {
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("no scope requested")
+	}
+	slices.Sort(scopes)
+	return scopes, nil
+}
+
+// scopeAllows reports whether a token with the granted scope may act with
+// the wanted scope. First-party tokens have every scope.
+func scopeAllows(firstParty bool, granted, wanted string) bool {
+	if firstParty {
+		return true
+	}
+	return slices.Contains(strings.Fields(granted), wanted)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pjjimiso/chirpy/internal/auth"
+)
+
+// Browser sessions keep tokens in cookies that scripts can't read, so an XSS
+// bug can't steal them. The __Host- prefix stops other subdomains from
+// planting their own CSRF cookie to pass the double-submit check.
+const (
+	accessTokenCookie	= "__Host-chirpy_access"
+	// Scoped to /api, where the refresh and revoke endpoints live, so it
+	// isn't sent with requests for the static frontend
+	refreshTokenCookie	= "__Secure-chirpy_refresh"
+	csrfCookie		= "__Host-chirpy_csrf"
+	csrfHeader		= "X-CSRF-Token"
+)
+
+// setSessionCookies stores a new session in the browser. The CSRF token is
+// the one cookie scripts can read, so the app can echo it in csrfHeader.
+func setSessionCookies(w http.ResponseWriter, accessToken string, accessExpiresIn time.Duration, refreshToken string, refreshExpiresAt time.Time) (string, error) {
+	csrfToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	setAccessTokenCookie(w, accessToken, accessExpiresIn)
+	http.SetCookie(w, &http.Cookie{
+		Name:		refreshTokenCookie,
+		Value:		refreshToken,
+		Path:		"/api",
+		Expires:	refreshExpiresAt,
+		HttpOnly:	true,
+		Secure:		true,
+		SameSite:	http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:		csrfCookie,
+		Value:		csrfToken,
+		Path:		"/",
+		Expires:	refreshExpiresAt,
+		Secure:		true,
+		SameSite:	http.SameSiteStrictMode,
+	})
+	return csrfToken, nil
+}
+
+func setAccessTokenCookie(w http.ResponseWriter, accessToken string, expiresIn time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:		accessTokenCookie,
+		Value:		accessToken,
+		Path:		"/",
+		MaxAge:		int(expiresIn.Seconds()),
+		HttpOnly:	true,
+		Secure:		true,
+		SameSite:	http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{
+		accessTokenCookie:	"/",
+		refreshTokenCookie:	"/api",
+		csrfCookie:		"/",
+	} {
+		http.SetCookie(w, &http.Cookie{
+			Name:		name,
+			Value:		"",
+			Path:		path,
+			MaxAge:		-1,
+			HttpOnly:	name != csrfCookie,
+			Secure:		true,
+			SameSite:	http.SameSiteStrictMode,
+		})
+	}
+}
+
+// requestToken returns the token from the Authorization header or the named
+// session cookie, see auth.RequestToken
+func requestToken(r *http.Request, cookieName string) (token string, fromCookie bool, err error) {
+	return auth.RequestToken(r, cookieName, csrfCookie, csrfHeader)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// goBackground runs f in a goroutine that shutdown waits for, so emails and
+// exports started by a request aren't cut off halfway through
+func (cfg *apiConfig) goBackground(f func()) {
+	cfg.background.Add(1)
+	go func() {
+		defer cfg.background.Done()
+		f()
+	}()
+}
+
+// waitBackground waits for every goBackground goroutine to return, or for
+// ctx to be done
+func (cfg *apiConfig) waitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		cfg.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops Chirpy in order once a signal arrives: readiness is flipped
+// first and given drainDelay to be noticed, then the server stops accepting
+// connections and finishes in-flight requests, then the background workers
+// are stopped and waited for, and finally the database pool is closed. Every
+// step after the drain delay shares the timeout.
+func (cfg *apiConfig) shutdown(srv *http.Server, stopWorkers context.CancelFunc, db *sql.DB, drainDelay, timeout time.Duration) error {
+	cfg.draining.Store(true)
+	if drainDelay > 0 {
+		slog.Info("Draining before shutting down", "delay", drainDelay.String())
+		time.Sleep(drainDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	stopWorkers()
+	err = cfg.waitBackground(ctx)
+	if err != nil {
+		errs = append(errs, errors.New("background work didn't finish before the shutdown timeout"))
+	}
+
+	err = db.Close()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -31,3 +31,18 @@ WHERE id = $1;
 -- name: DeleteChirp :exec
 DELETE FROM chirps 
 WHERE id = $1 AND user_id = $2;
+
+-- name: AnonymizeUserChirps :exec
+UPDATE chirps
+SET user_id = NULL, updated_at = NOW()
+WHERE user_id = $1;
+
+-- name: CountUserChirpsSince :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1 AND created_at > $2;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $3, updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING *;
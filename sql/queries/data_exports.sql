@@ -0,0 +1,52 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports(
+	id,
+	created_at,
+	user_id,
+	status
+)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	$1,
+	'pending'
+)
+RETURNING id, created_at, user_id, status, completed_at, expires_at;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status = 'ready', archive = $2, completed_at = NOW(), expires_at = $3
+WHERE id = $1;
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET status = 'failed', completed_at = NOW()
+WHERE id = $1;
+
+-- name: FailStaleDataExports :exec
+-- Builds run in process, so one still pending long after it started was cut
+-- off by a restart and will never finish
+UPDATE data_exports
+SET status = 'failed', completed_at = NOW()
+WHERE status = 'pending' AND created_at < sqlc.arg(started_before);
+
+-- name: GetDataExport :one
+SELECT id, created_at, user_id, status, completed_at, expires_at FROM data_exports
+WHERE id = $1;
+
+-- name: GetDataExportArchive :one
+SELECT archive FROM data_exports
+WHERE id = $1 AND status = 'ready' AND expires_at > NOW();
+
+-- name: HasPendingDataExport :one
+SELECT EXISTS (
+	SELECT 1 FROM data_exports
+	WHERE user_id = $1 AND status = 'pending'
+);
+
+-- name: DeleteExpiredDataExports :exec
+-- Failed exports never get an expiry, so they go once they're older than
+-- the cutoff
+DELETE FROM data_exports
+WHERE expires_at <= NOW()
+OR (status = 'failed' AND created_at < sqlc.arg(failed_before));
@@ -0,0 +1,23 @@
+-- name: DeleteExpiredDeniedTokens :execrows
+-- An expired token is rejected anyway, so its entry is no longer needed
+DELETE FROM denied_tokens
+WHERE expires_at <= NOW();
+
+-- name: DenyToken :exec
+INSERT INTO denied_tokens(
+	jti,
+	created_at,
+	expires_at
+)
+VALUES (
+	$1,
+	NOW(),
+	$2
+)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: IsTokenDenied :one
+SELECT EXISTS(
+	SELECT 1 FROM denied_tokens
+	WHERE jti = $1
+);
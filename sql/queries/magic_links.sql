@@ -0,0 +1,21 @@
+-- name: CreateMagicLinkToken :exec
+INSERT INTO magic_link_tokens(
+	token_hash,
+	created_at,
+	user_id,
+	nonce_hash,
+	expires_at
+)
+VALUES (
+	$1,
+	NOW(),
+	$2,
+	$3,
+	$4
+);
+
+-- name: ConsumeMagicLinkToken :one
+UPDATE magic_link_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND nonce_hash = $2 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id;
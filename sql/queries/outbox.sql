@@ -0,0 +1,45 @@
+-- name: CreateOutboxEvent :exec
+INSERT INTO outbox(
+	id,
+	created_at,
+	event_type,
+	user_id,
+	payload,
+	next_attempt_at
+)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	$1,
+	$2,
+	$3,
+	NOW()
+);
+
+-- name: ClaimOutboxEvents :many
+-- Leases unpublished events by pushing next_attempt_at out, so events held
+-- by a relay that dies are picked up again once the lease runs out
+UPDATE outbox
+SET next_attempt_at = sqlc.arg(lease_until)
+WHERE id IN (
+	SELECT id FROM outbox
+	WHERE published_at IS NULL AND next_attempt_at <= NOW()
+	ORDER BY created_at ASC
+	LIMIT sqlc.arg(max_results)
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkOutboxEventPublished :exec
+UPDATE outbox
+SET published_at = NOW(), attempts = attempts + 1, last_error = NULL
+WHERE id = $1;
+
+-- name: RetryOutboxEvent :exec
+UPDATE outbox
+SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+WHERE id = $1;
+
+-- name: DeletePublishedOutboxEvents :exec
+DELETE FROM outbox
+WHERE published_at < $1;
@@ -17,8 +17,31 @@ VALUES (
 )
 RETURNING *;
 
+-- name: CreateClientRefreshToken :one
+INSERT INTO refresh_tokens(
+	token,
+	created_at,
+	updated_at,
+	user_id,
+	expires_at,
+	revoked_at,
+	client_id,
+	scope
+)
+VALUES (
+	$1,
+	NOW(),
+	NOW(),
+	$2,
+	$3,
+	NULL,
+	$4,
+	$5
+)
+RETURNING *;
+
 -- name: GetUserFromRefreshToken :one
-SELECT token, user_id, expires_at, revoked_at
+SELECT token, user_id, expires_at, revoked_at, access_token_jti, access_token_expires_at, client_id, scope
 FROM refresh_tokens
 WHERE token = $1;
 
@@ -26,3 +49,20 @@ WHERE token = $1;
 UPDATE refresh_tokens
 SET revoked_at = NOW(), expires_at = NOW(), updated_at = NOW()
 WHERE token = $1;
+
+-- name: UpdateRefreshTokenAccessToken :exec
+UPDATE refresh_tokens
+SET access_token_jti = $2, access_token_expires_at = $3, updated_at = NOW()
+WHERE token = $1;
+
+-- name: RevokeUserRefreshTokens :many
+UPDATE refresh_tokens
+SET revoked_at = NOW(), expires_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+RETURNING access_token_jti, access_token_expires_at;
+
+-- name: ListUserRefreshTokens :many
+SELECT created_at, updated_at, expires_at, revoked_at, client_id, scope
+FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at ASC;
@@ -0,0 +1,59 @@
+-- name: LockSubscription :one
+SELECT * FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: UpsertSubscription :exec
+INSERT INTO subscriptions(
+	user_id,
+	created_at,
+	updated_at,
+	status,
+	current_period_start,
+	current_period_end
+)
+VALUES (
+	$1,
+	NOW(),
+	NOW(),
+	$2,
+	$3,
+	$4
+)
+ON CONFLICT (user_id) DO UPDATE
+SET status = EXCLUDED.status,
+	current_period_start = EXCLUDED.current_period_start,
+	current_period_end = EXCLUDED.current_period_end,
+	updated_at = NOW();
+
+-- name: CreateSubscriptionPeriod :exec
+INSERT INTO subscription_periods(
+	id,
+	created_at,
+	user_id,
+	starts_at,
+	ends_at,
+	event_id
+)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	$1,
+	$2,
+	$3,
+	$4
+);
+
+-- name: GetLapsedSubscriptions :many
+SELECT * FROM subscriptions
+WHERE status IN ('active', 'past_due', 'cancelled')
+AND current_period_end <= NOW();
+
+-- name: GetSubscription :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: ListSubscriptionPeriods :many
+SELECT * FROM subscription_periods
+WHERE user_id = $1
+ORDER BY starts_at ASC;
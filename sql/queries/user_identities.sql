@@ -0,0 +1,24 @@
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities(
+	id,
+	created_at,
+	user_id,
+	issuer,
+	subject
+)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	$1,
+	$2,
+	$3
+);
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE issuer = $1 AND subject = $2;
+
+-- name: ListUserIdentities :many
+SELECT * FROM user_identities
+WHERE user_id = $1
+ORDER BY created_at ASC;
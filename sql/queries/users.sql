@@ -13,7 +13,7 @@ VALUES (
 	$1,
 	$2
 )
-RETURNING id, created_at, updated_at, email, is_chirpy_red;
+RETURNING id, created_at, updated_at, email, is_chirpy_red, email_verified;
 
 -- name: TruncateUsers :exec
 TRUNCATE TABLE users CASCADE;
@@ -22,13 +22,64 @@ TRUNCATE TABLE users CASCADE;
 SELECT * FROM users
 WHERE email = $1;
 
--- name: UpdateUserCredentials :exec
+-- name: SetUserChirpyRed :exec
 UPDATE users
-SET email = $1, hashed_passwords = $2, updated_at = NOW()
-WHERE id = $3;
+SET is_chirpy_red = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: UpdateUserTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2, totp_enabled = FALSE, updated_at = NOW()
+WHERE id = $1;
 
--- name: UpdateUserAddChirpyRed :exec
+-- name: EnableUserTOTP :exec
 UPDATE users
-SET is_chirpy_red = TRUE 
+SET totp_enabled = TRUE, totp_last_step = $2, updated_at = NOW()
 WHERE id = $1;
 
+-- name: UpdateUserTOTPLastStep :execrows
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1 AND totp_last_step < $2;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_passwords = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetUserPendingEmail :exec
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified = TRUE, updated_at = NOW()
+WHERE id = $1 AND email = $2;
+
+-- name: ConfirmUserPendingEmail :execrows
+UPDATE users
+SET email = pending_email, pending_email = NULL, email_verified = TRUE, updated_at = NOW()
+WHERE id = $1 AND pending_email = $2;
+
+-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET delete_after = $2, keep_chirps = $3, updated_at = NOW()
+WHERE id = $1;
+
+-- name: CancelUserDeletion :execrows
+UPDATE users
+SET delete_after = NULL, keep_chirps = FALSE, updated_at = NOW()
+WHERE id = $1 AND delete_after IS NOT NULL;
+
+-- name: GetUsersDueForDeletion :many
+SELECT id, keep_chirps FROM users
+WHERE delete_after <= NOW();
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1 AND delete_after <= NOW();
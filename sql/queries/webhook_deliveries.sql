@@ -0,0 +1,98 @@
+-- name: EnqueueWebhookDeliveries :execrows
+-- Queues the event for every endpoint subscribed to it: the subject user's
+-- own endpoints and all admin endpoints
+INSERT INTO webhook_deliveries(
+	id,
+	created_at,
+	endpoint_id,
+	event_id,
+	event_type,
+	payload,
+	status,
+	next_attempt_at
+)
+SELECT gen_random_uuid(), NOW(), e.id, sqlc.arg(event_id), sqlc.arg(event_type)::text, sqlc.arg(payload), 'pending', NOW()
+FROM webhook_endpoints e
+WHERE sqlc.arg(event_type)::text = ANY(e.event_types)
+AND (e.user_id IS NULL OR e.user_id = sqlc.arg(user_id))
+ON CONFLICT (endpoint_id, event_id) DO NOTHING;
+
+-- name: ClaimWebhookDeliveries :many
+-- Leases due deliveries by pushing next_attempt_at out, so a worker that
+-- dies mid-delivery only delays them
+UPDATE webhook_deliveries d
+SET next_attempt_at = sqlc.arg(lease_until)
+FROM webhook_endpoints e
+WHERE d.endpoint_id = e.id
+AND d.id IN (
+	SELECT id FROM webhook_deliveries
+	WHERE status = 'pending' AND next_attempt_at <= NOW()
+	ORDER BY next_attempt_at ASC
+	LIMIT sqlc.arg(max_results)
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING d.id, d.event_id, d.payload, d.attempts, e.url, e.secret;
+
+-- name: RecordWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts(
+	id,
+	delivery_id,
+	attempted_at,
+	status_code,
+	error,
+	duration_ms
+)
+VALUES (
+	gen_random_uuid(),
+	$1,
+	NOW(),
+	$2,
+	$3,
+	$4
+);
+
+-- name: MarkWebhookDeliveryDelivered :exec
+UPDATE webhook_deliveries
+SET status = 'delivered', attempts = attempts + 1, last_attempt_at = NOW(), delivered_at = NOW()
+WHERE id = $1;
+
+-- name: RetryWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET attempts = attempts + 1, last_attempt_at = NOW(), next_attempt_at = $2
+WHERE id = $1;
+
+-- name: DeadLetterWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET status = 'dead', attempts = attempts + 1, last_attempt_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteFinishedWebhookDeliveries :exec
+-- Their attempts are deleted with them
+DELETE FROM webhook_deliveries
+WHERE status IN ('delivered', 'dead') AND last_attempt_at < sqlc.arg(finished_before);
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT 100;
+
+-- name: ListUserWebhookDeliveries :many
+SELECT d.* FROM webhook_deliveries d
+JOIN webhook_endpoints e ON e.id = d.endpoint_id
+WHERE e.user_id = $1
+ORDER BY d.created_at ASC;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries
+WHERE id = $1 AND endpoint_id = $2;
+
+-- name: ListWebhookDeliveryAttempts :many
+SELECT * FROM webhook_delivery_attempts
+WHERE delivery_id = $1
+ORDER BY attempted_at ASC;
+
+-- name: RedeliverWebhookDelivery :execrows
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+WHERE id = $1 AND endpoint_id = $2 AND status <> 'pending';
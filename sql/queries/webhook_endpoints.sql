@@ -0,0 +1,37 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints(
+	id,
+	created_at,
+	updated_at,
+	user_id,
+	url,
+	secret,
+	event_types
+)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	NOW(),
+	$1,
+	$2,
+	$3,
+	$4
+)
+RETURNING *;
+
+-- name: CountWebhookEndpoints :one
+SELECT COUNT(*) FROM webhook_endpoints
+WHERE user_id IS NOT DISTINCT FROM $1;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+WHERE user_id IS NOT DISTINCT FROM $1
+ORDER BY created_at ASC;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints
+WHERE id = $1 AND user_id IS NOT DISTINCT FROM $2;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1 AND user_id IS NOT DISTINCT FROM $2;
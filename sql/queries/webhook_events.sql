@@ -0,0 +1,43 @@
+-- name: RecordWebhookEvent :execrows
+INSERT INTO webhook_events(
+	id,
+	source,
+	event_type,
+	payload,
+	received_at,
+	status
+)
+VALUES (
+	$1,
+	$2,
+	$3,
+	$4,
+	NOW(),
+	'received'
+)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: LockWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1
+FOR UPDATE;
+
+-- name: FinishWebhookEvent :exec
+UPDATE webhook_events
+SET status = $2, processed_at = NOW(), error = NULL, attempts = attempts + 1
+WHERE id = $1;
+
+-- name: FailWebhookEvent :exec
+UPDATE webhook_events
+SET status = 'failed', error = $2, attempts = attempts + 1
+WHERE id = $1;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE sqlc.arg(status)::text = '' OR status = sqlc.arg(status)::text
+ORDER BY received_at DESC
+LIMIT sqlc.arg(max_results);
@@ -0,0 +1,17 @@
+-- +goose up
+CREATE TABLE denied_tokens (
+	jti TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE refresh_tokens
+ADD COLUMN access_token_jti TEXT DEFAULT NULL,
+ADD COLUMN access_token_expires_at TIMESTAMP DEFAULT NULL;
+
+-- +goose down
+ALTER TABLE refresh_tokens
+DROP COLUMN access_token_jti,
+DROP COLUMN access_token_expires_at;
+
+DROP TABLE denied_tokens;
@@ -0,0 +1,21 @@
+-- +goose up
+ALTER TABLE users
+ADD COLUMN totp_secret TEXT DEFAULT NULL,
+ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	hashed_code TEXT NOT NULL,
+	used_at TIMESTAMP DEFAULT NULL
+);
+
+-- +goose down
+DROP TABLE recovery_codes;
+
+ALTER TABLE users
+DROP COLUMN totp_secret,
+DROP COLUMN totp_enabled,
+DROP COLUMN totp_last_step;
@@ -0,0 +1,11 @@
+-- +goose up
+CREATE TABLE password_reset_tokens (
+	token_hash TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP DEFAULT NULL
+);
+
+-- +goose down
+DROP TABLE password_reset_tokens;
@@ -0,0 +1,12 @@
+-- +goose up
+ALTER TABLE users
+ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN pending_email TEXT DEFAULT NULL;
+
+-- Accounts created before verification existed keep posting
+UPDATE users SET email_verified = TRUE;
+
+-- +goose down
+ALTER TABLE users
+DROP COLUMN email_verified,
+DROP COLUMN pending_email;
@@ -0,0 +1,15 @@
+-- +goose up
+CREATE TABLE api_tokens (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	scope TEXT NOT NULL,
+	expires_at TIMESTAMP DEFAULT NULL,
+	last_used_at TIMESTAMP DEFAULT NULL,
+	revoked_at TIMESTAMP DEFAULT NULL
+);
+
+-- +goose down
+DROP TABLE api_tokens;
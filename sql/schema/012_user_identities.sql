@@ -0,0 +1,12 @@
+-- +goose up
+CREATE TABLE user_identities (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	UNIQUE (issuer, subject)
+);
+
+-- +goose down
+DROP TABLE user_identities;
@@ -0,0 +1,12 @@
+-- +goose up
+CREATE TABLE magic_link_tokens (
+	token_hash TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	nonce_hash TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP DEFAULT NULL
+);
+
+-- +goose down
+DROP TABLE magic_link_tokens;
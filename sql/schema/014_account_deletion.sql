@@ -0,0 +1,19 @@
+-- +goose up
+ALTER TABLE users
+ADD COLUMN delete_after TIMESTAMP DEFAULT NULL,
+ADD COLUMN keep_chirps BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Chirps kept from deleted accounts have no author
+ALTER TABLE chirps
+ALTER COLUMN user_id DROP NOT NULL;
+
+-- +goose down
+DELETE FROM chirps
+WHERE user_id IS NULL;
+
+ALTER TABLE chirps
+ALTER COLUMN user_id SET NOT NULL;
+
+ALTER TABLE users
+DROP COLUMN delete_after,
+DROP COLUMN keep_chirps;
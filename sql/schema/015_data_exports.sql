@@ -0,0 +1,13 @@
+-- +goose up
+CREATE TABLE data_exports (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	status TEXT NOT NULL,
+	archive BYTEA DEFAULT NULL,
+	completed_at TIMESTAMP DEFAULT NULL,
+	expires_at TIMESTAMP DEFAULT NULL
+);
+
+-- +goose down
+DROP TABLE data_exports;
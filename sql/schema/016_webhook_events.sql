@@ -0,0 +1,17 @@
+-- +goose up
+CREATE TABLE webhook_events (
+	id TEXT PRIMARY KEY,
+	source TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	received_at TIMESTAMP NOT NULL,
+	processed_at TIMESTAMP DEFAULT NULL,
+	status TEXT NOT NULL,
+	error TEXT DEFAULT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX webhook_events_status_idx ON webhook_events (status, received_at);
+
+-- +goose down
+DROP TABLE webhook_events;
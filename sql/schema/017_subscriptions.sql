@@ -0,0 +1,30 @@
+-- +goose up
+CREATE TABLE subscriptions (
+	user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	status TEXT NOT NULL,
+	current_period_start TIMESTAMP NOT NULL,
+	current_period_end TIMESTAMP NOT NULL
+);
+
+CREATE INDEX subscriptions_period_end_idx ON subscriptions (current_period_end);
+
+CREATE TABLE subscription_periods (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	starts_at TIMESTAMP NOT NULL,
+	ends_at TIMESTAMP NOT NULL,
+	event_id TEXT DEFAULT NULL
+);
+
+-- Existing Chirpy Red members start on a fresh period
+INSERT INTO subscriptions (user_id, created_at, updated_at, status, current_period_start, current_period_end)
+SELECT id, NOW(), NOW(), 'active', NOW(), NOW() + INTERVAL '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose down
+DROP TABLE subscription_periods;
+DROP TABLE subscriptions;
@@ -0,0 +1,41 @@
+-- +goose up
+CREATE TABLE webhook_endpoints (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	-- NULL for endpoints registered by an admin, which get every event
+	user_id UUID DEFAULT NULL REFERENCES users (id) ON DELETE CASCADE,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	event_types TEXT[] NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	endpoint_id UUID NOT NULL REFERENCES webhook_endpoints (id) ON DELETE CASCADE,
+	event_id UUID NOT NULL,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_attempt_at TIMESTAMP DEFAULT NULL,
+	delivered_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt_at);
+
+CREATE TABLE webhook_delivery_attempts (
+	id UUID PRIMARY KEY,
+	delivery_id UUID NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+	attempted_at TIMESTAMP NOT NULL,
+	status_code INTEGER DEFAULT NULL,
+	error TEXT DEFAULT NULL,
+	duration_ms INTEGER NOT NULL
+);
+
+-- +goose down
+DROP TABLE webhook_delivery_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_endpoints;
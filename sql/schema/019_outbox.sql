@@ -0,0 +1,23 @@
+-- +goose up
+CREATE TABLE outbox (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	event_type TEXT NOT NULL,
+	-- No foreign key, so events about a deleted user are still published
+	user_id UUID DEFAULT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_error TEXT DEFAULT NULL,
+	published_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (next_attempt_at) WHERE published_at IS NULL;
+
+-- The relay may publish an event more than once, so webhook deliveries are
+-- deduplicated by event
+CREATE UNIQUE INDEX webhook_deliveries_event_idx ON webhook_deliveries (endpoint_id, event_id);
+
+-- +goose down
+DROP INDEX webhook_deliveries_event_idx;
+DROP TABLE outbox;
@@ -0,0 +1,6 @@
+-- +goose up
+-- Expired denylist entries are deleted periodically
+CREATE INDEX denied_tokens_expires_at_idx ON denied_tokens (expires_at);
+
+-- +goose down
+DROP INDEX denied_tokens_expires_at_idx;
@@ -0,0 +1,10 @@
+-- +goose up
+-- Recovery codes are looked up by their SHA-256 digest. Codes hashed with
+-- argon2id can't be converted, so they're removed.
+DELETE FROM recovery_codes
+WHERE hashed_code LIKE '$%';
+
+CREATE UNIQUE INDEX recovery_codes_user_id_hashed_code_idx ON recovery_codes (user_id, hashed_code);
+
+-- +goose down
+DROP INDEX recovery_codes_user_id_hashed_code_idx;
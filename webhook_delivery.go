@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pjjimiso/chirpy/internal/database"
+	"github.com/pjjimiso/chirpy/internal/events"
+	"github.com/pjjimiso/chirpy/internal/webhooks"
+)
+
+const (
+	webhookDeliveryBatch	= 20
+	// Long enough for a whole batch to time out one by one
+	webhookDeliveryLease	= 5 * time.Minute
+	// Finished deliveries and their attempt logs are kept this long
+	webhookDeliveryRetention	= 30 * 24 * time.Hour
+)
+
+// enqueueWebhookDeliveries is subscribed to the event bus, and queues events
+// that webhooks can be sent for to every endpoint subscribed to them. The
+// outbox event ID is reused, so an event published twice is only queued once.
+func (cfg *apiConfig) enqueueWebhookDeliveries(ctx context.Context, event events.Event) error {
+	if !webhooks.ValidEventType(event.Type) {
+		return nil
+	}
+	payload, err := json.Marshal(webhooks.Event{
+		ID:		event.ID,
+		Type:		event.Type,
+		CreatedAt:	event.CreatedAt.UTC(),
+		Data:		event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cfg.db.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID:	event.ID,
+		EventType:	event.Type,
+		Payload:	string(payload),
+		UserID:		uuid.NullUUID{UUID: event.UserID, Valid: event.UserID != uuid.Nil},
+	})
+	return err
+}
+
+// runWebhookDelivery sends queued webhook deliveries, checking for due ones
+// every interval until ctx is cancelled. A batch that's already claimed is
+// finished first. Old finished deliveries are cleaned up hourly.
+func (cfg *apiConfig) runWebhookDelivery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+	for {
+		cfg.deliverDueWebhooks(ctx)
+		if time.Since(lastCleanup) > time.Hour {
+			cfg.cleanUpWebhookDeliveries()
+			lastCleanup = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDueWebhooks sends claimed deliveries one at a time until none are
+// due or ctx is cancelled. Deliveries claimed but not reached before shutdown
+// stay leased and are picked up again once the lease runs out.
+func (cfg *apiConfig) deliverDueWebhooks(ctx context.Context) {
+	for ctx.Err() == nil {
+		claimCtx, cancel := context.WithTimeout(ctx, 10 * time.Second)
+		deliveries, err := cfg.db.ClaimWebhookDeliveries(claimCtx, database.ClaimWebhookDeliveriesParams{
+			LeaseUntil:	time.Now().Add(webhookDeliveryLease),
+			MaxResults:	webhookDeliveryBatch,
+		})
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Error claiming webhook deliveries", "error", err)
+			}
+			return
+		}
+		for _, delivery := range deliveries {
+			if ctx.Err() != nil {
+				return
+			}
+			cfg.deliverWebhook(delivery)
+		}
+		if len(deliveries) < webhookDeliveryBatch {
+			return
+		}
+	}
+}
+
+func (cfg *apiConfig) cleanUpWebhookDeliveries() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := cfg.db.DeleteFinishedWebhookDeliveries(ctx, sql.NullTime{Time: time.Now().Add(-webhookDeliveryRetention), Valid: true})
+	if err != nil {
+		slog.Error("Error cleaning up webhook deliveries", "error", err)
+	}
+}
+
+// deliverWebhook makes one attempt at a delivery and logs it, then marks the
+// delivery delivered, schedules a retry with backoff, or dead-letters it once
+// it's out of attempts
+func (cfg *apiConfig) deliverWebhook(delivery database.ClaimWebhookDeliveriesRow) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15 * time.Second)
+	defer cancel()
+
+	start := time.Now()
+	status, sendErr := webhooks.Send(ctx, cfg.webhookClient, delivery.Url, delivery.Secret, delivery.EventID, []byte(delivery.Payload))
+	attempt := database.RecordWebhookDeliveryAttemptParams{
+		DeliveryID:	delivery.ID,
+		DurationMs:	int32(time.Since(start).Milliseconds()),
+	}
+	if status != 0 {
+		attempt.StatusCode = sql.NullInt32{Int32: int32(status), Valid: true}
+	}
+	if sendErr != nil {
+		attempt.Error = sql.NullString{String: sendErr.Error(), Valid: true}
+	}
+	err := cfg.db.RecordWebhookDeliveryAttempt(ctx, attempt)
+	if err != nil {
+		slog.Error("Error logging webhook delivery attempt", "delivery_id", delivery.ID, "error", err)
+	}
+
+	attempts := int(delivery.Attempts) + 1
+	switch {
+	case sendErr == nil:
+		err = cfg.db.MarkWebhookDeliveryDelivered(ctx, delivery.ID)
+	case attempts >= webhooks.MaxAttempts:
+		err = cfg.db.DeadLetterWebhookDelivery(ctx, delivery.ID)
+	default:
+		err = cfg.db.RetryWebhookDelivery(ctx, database.RetryWebhookDeliveryParams{
+			ID:		delivery.ID,
+			NextAttemptAt:	time.Now().Add(webhooks.Backoff(attempts)),
+		})
+	}
+	if err != nil {
+		slog.Error("Error updating webhook delivery", "delivery_id", delivery.ID, "error", err)
+	}
+}